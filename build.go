@@ -1,10 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"html"
 	"html/template"
-	"io"
 	"net/url"
 	"os"
 	"path"
@@ -21,8 +22,13 @@ import (
 	"golang.org/x/xerrors"
 
 	"coolstercodes/modules/modulir"
+	"coolstercodes/modules/modulir/massets"
+	"coolstercodes/modules/modulir/matom"
 	"coolstercodes/modules/modulir/mfile"
+	"coolstercodes/modules/modulir/mimage"
+	"coolstercodes/modules/modulir/mjsonfeed"
 	"coolstercodes/modules/modulir/mmarkdownext"
+	"coolstercodes/modules/modulir/msitemap"
 	"coolstercodes/modules/modulir/mtemplate"
 	"coolstercodes/modules/modulir/mtoc"
 	"coolstercodes/modules/modulir/mtoml"
@@ -44,6 +50,18 @@ const (
 	MTags = 2
 )
 
+// imageSizes is the `sizes` attribute of images in articles and pages, which
+// fill the width of the content column.
+const imageSizes = "(min-width: 1280px) 860px, (min-width: 768px) 670px, 100vw"
+
+// Keys under which state is persisted to Modulir's build cache.
+const (
+	cacheKeyArticles      = "articles"
+	cacheKeyArticleStates = "article_states"
+	cacheKeyDependencies  = "dependencies"
+	cacheKeyPages         = "pages"
+)
+
 //////////////////////////////////////////////////////////////////////////////
 //
 //
@@ -59,14 +77,15 @@ const (
 // reparsing all the source material. In each case we try to only reparse the
 // sources if those source files actually changed.
 var (
-	articles     []*Article
-	pages        []*Page
-	dependencies = NewDependencyRegistry()
+	articles      []*Article
+	articleStates map[string]*articleState
+	pages         []*Page
+	dependencies  = NewDependencyRegistry()
 )
 
 // List of common build dependencies, a change in any of which will trigger a
-// rebuild on everything: partial html, JavaScripts, and stylesheets. Even
-// though some of those changes will false positives, these sources are
+// rebuild on everything: partial html and JavaScripts. Even though some of
+// those changes will false positives, these sources are
 // pervasive enough, and changes infrequent enough, that it's worth the
 // tradeoff. This variable is a global because so many render functions access
 // it.
@@ -74,6 +93,11 @@ var universalSources []string
 
 var validate = validator.New()
 
+// Widths at which resized variants of article and page images are written, up
+// to the width of each image. They cover the content column on phones up to
+// high density displays on desktops.
+var imageVariantWidths = []int{480, 960, 1440}
+
 //////////////////////////////////////////////////////////////////////////////
 //
 //
@@ -108,24 +132,35 @@ func build(c *modulir.Context) []error {
 
 	c.Log.Debugf("Running build loop")
 
+	// On the first run, restore state persisted by a previous process (if
+	// any) so that only sources that changed since then need to be rebuilt.
+	// The same state is saved again after every successful build.
+	if c.FirstRun {
+		if err := loadCachedState(c); err != nil {
+			return []error{err}
+		}
+	}
+
+	c.StoreCached(cacheKeyArticles, &articles)
+	c.StoreCached(cacheKeyArticleStates, &articleStates)
+	c.StoreCached(cacheKeyDependencies, dependencies)
+	c.StoreCached(cacheKeyPages, &pages)
+
 	// This is where we stored content like compiled JS and CSS.
 	contentDir := path.Join(c.TargetDir, "content")
 
 	// A set of source paths that rebuild everything when any one of them
 	// changes. These are dependencies that are included in more or less
-	// everything: common partial html, JavaScript sources, and stylesheet
-	// sources.
+	// everything: common partial html and JavaScript sources.
 	universalSources = nil
 
 	// Generate a set of JavaScript sources to add to universal sources.
-	{
-		javaScriptSources, err := mfile.ReadDirCached(c, c.SourceDir+"/web/javascripts",
-			&mfile.ReadDirOptions{ShowMeta: true})
-		if err != nil {
-			return []error{err}
-		}
-		universalSources = append(universalSources, javaScriptSources...)
+	javaScriptSources, err := mfile.ReadDirCached(c, c.SourceDir+"/web/javascripts",
+		&mfile.ReadDirOptions{ShowMeta: true})
+	if err != nil {
+		return []error{err}
 	}
+	universalSources = append(universalSources, javaScriptSources...)
 
 	// Generate a list of partial html to add to universal sources.
 	{
@@ -145,34 +180,35 @@ func build(c *modulir.Context) []error {
 		universalSources = append(universalSources, partialHTML...)
 	}
 
-	// Generate a set of stylesheet sources to add to universal sources.
-	{
-		stylesheetSources, err := mfile.ReadDirCached(c, c.SourceDir+"/web/stylesheets",
-			&mfile.ReadDirOptions{ShowMeta: true})
-		if err != nil {
-			return []error{err}
-		}
-		universalSources = append(universalSources, stylesheetSources...)
+	// Stylesheets aren't universal sources because pages only link to them
+	// and they're symlinked or copied into the target directory, so changing
+	// one doesn't require rendering anything.
+	stylesheetSources, err := mfile.ReadDirCached(c, c.SourceDir+"/web/stylesheets",
+		&mfile.ReadDirOptions{ShowMeta: true})
+	if err != nil {
+		return []error{err}
 	}
 
 	//
-	// PHASE 1
+	// JOBS
 	//
-	// The build is broken into phases because some jobs depend on jobs that
-	// ran before them. For example, we need to parse all our article metadata
-	// before we can create an article index and render the home page (which
-	// contains a short list of articles).
+	// Some jobs depend on jobs that run before them. For example, we need to
+	// parse all our article metadata before we can create an article index
+	// and render the home page (which contains a short list of articles).
 	//
-	// After each phase, we call `Wait` on our context which will wait for the
-	// worker pool to finish all its current work and restart it to accept new
-	// jobs after it has.
+	// Rather than breaking the build into phases, jobs declare the jobs that
+	// they depend on with `AddJobAfter`, and the pool runs each one as soon
+	// as its dependencies are done. If a dependency fails, jobs depending on
+	// it fail without running.
 	//
 	// The general rule is to make sure that work is done as early as it
-	// possibly can be. e.g. Jobs with no dependencies should always run in
-	// phase 1. Try to make sure that as few phases as necessary.
+	// possibly can be, so only declare dependencies that are really needed.
+	//
+	// Jobs added with the `Ctx` variants receive a context that's cancelled
+	// if they run past their timeout, or if the build is interrupted (say,
+	// because a new change came in while looping). Pass it down to anything
+	// that can take a while.
 	//
-
-	ctx := context.Background()
 
 	//
 	// Common directories
@@ -195,29 +231,126 @@ func build(c *modulir.Context) []error {
 	}
 
 	//
-	// Symlinks
+	// Assets
+	//
+	// JavaScripts and stylesheets are either copied or symlinked into the
+	// target directory. Copying makes the target self-contained so that it
+	// can be archived or moved elsewhere, and leaves out files that pages
+	// don't need like source maps. Only files that changed are copied again.
+	// Symlinking is faster and picks up changes without a build, so it's
+	// used when looping by default.
+	//
+	// Files are copied or linked one by one into real directories rather
+	// than linking the directories themselves so that files produced from
+	// them (like compressed variants) are written to the target instead of
+	// the source. They're recorded as outputs so that those of removed
+	// sources are pruned.
+	//
+	// Templates refer to these files with the `Asset` helper, which looks
+	// them up in an asset manifest. When fingerprinting, each is also copied
+	// to a name containing a hash of its contents and the manifest points to
+	// that copy instead. Files are kept under their original names either
+	// way because assets refer to each other (and their source maps) by
+	// those names.
 	//
 
 	{
-		commonSymlinks := [][2]string{
-			{c.SourceDir + "/web/javascripts", contentDir + "/javascripts"},
-			{c.SourceDir + "/web/stylesheets", contentDir + "/stylesheets"},
+		manifest := massets.NewManifest()
+
+		assetDirs := []struct {
+			name    string
+			sources []string
+			target  string
+		}{
+			{"javascripts", javaScriptSources, contentDir + "/javascripts"},
+			{"stylesheets", stylesheetSources, contentDir + "/stylesheets"},
 		}
-		for _, link := range commonSymlinks {
-			err := mfile.EnsureSymlink(c, link[0], link[1])
-			if err != nil {
-				return []error{nil}
+		for _, dir := range assetDirs {
+			// Builds used to link the whole directory, so replace a link
+			// left over from one of them.
+			if info, err := os.Lstat(dir.target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				if err := os.Remove(dir.target); err != nil {
+					return []error{xerrors.Errorf("error removing symlink: %w", err)}
+				}
+			}
+
+			if err := mfile.EnsureDir(c, dir.target); err != nil {
+				return []error{err}
+			}
+
+			sources := dir.sources
+			if copyAssets {
+				sources, err = mfile.FilterFiles(sources, &mfile.FilterOptions{
+					Exclude: assetCopyExclude,
+
+					// Pages link to unminified stylesheets in development.
+					ExcludeUnminified: conf.CCEnv != ccEnvDevelopment,
+				})
+				if err != nil {
+					return []error{err}
+				}
+			}
+
+			for _, source := range sources {
+				target := path.Join(dir.target, filepath.Base(source))
+				if copyAssets {
+					err = mfile.EnsureCopy(c, source, target)
+				} else {
+					err = mfile.EnsureSymlink(c, source, target)
+				}
+				if err != nil {
+					return []error{err}
+				}
+				c.AddOutput("assets", target)
+
+				name := dir.name + "/" + filepath.Base(source)
+				url := "/content/" + name
+
+				// Source maps are only ever found through the comment at the
+				// end of the script they belong to, which uses their
+				// original names.
+				if fingerprintAssets && filepath.Ext(source) != ".map" {
+					fingerprinted, err := massets.Fingerprint(source, dir.target)
+					if err != nil {
+						return []error{err}
+					}
+					c.AddOutput("assets", path.Join(dir.target, fingerprinted))
+
+					url = "/content/" + dir.name + "/" + fingerprinted
+				}
+
+				manifest.Add(name, url)
 			}
 		}
+
+		if fingerprintAssets {
+			manifestPath := path.Join(contentDir, assetManifestFile)
+			if _, err := manifest.Write(manifestPath); err != nil {
+				return []error{err}
+			}
+			c.AddOutput("assets", manifestPath)
+		}
+
+		massets.SetManifest(manifest)
 	}
 
 	//
-	// Recursively copy over article pictures into /content/images
+	// Stylesheets
+	//
+	// Nothing to build, but tells clients of the development websocket to
+	// swap in changed stylesheets rather than reloading the page when
+	// they're the only thing that changed.
 	//
 
-	if err := mfile.CopyDirectoryImages(c, c.SourceDir+"/content/articles", c.TargetDir+"/content/images"); err != nil {
-		return []error{err}
-	}
+	c.AddJob("stylesheets", func() (bool, error) {
+		for _, source := range stylesheetSources {
+			if c.Changed(source) {
+				c.HotSwapStylesheet(source, "/content/stylesheets/"+filepath.Base(source))
+			}
+		}
+
+		return false, nil
+	})
 
 	//
 	// Articles
@@ -225,6 +358,7 @@ func build(c *modulir.Context) []error {
 
 	var articlesChanged bool
 	var articlesMu sync.Mutex
+	var articleJobs []*modulir.Job
 
 	{
 		opts := mfile.ReadDirOptions{
@@ -236,14 +370,45 @@ func build(c *modulir.Context) []error {
 			return []error{err}
 		}
 
+		//
+		// Recursively copy over article pictures into /content/images,
+		// except for those of articles that are left out of the build
+		//
+
+		unpublishedDirs, err := getUnpublishedArticleDirs(c, sources)
+		if err != nil {
+			return []error{err}
+		}
+
+		if err := mfile.CopyDirectoryImagesWithOptions(c, c.SourceDir+"/content/articles",
+			c.TargetDir+"/content/images", &mfile.CopyDirectoryImagesOptions{ExcludeDirs: unpublishedDirs}); err != nil {
+			return []error{err}
+		}
+
+		imageJobs, err := addImageJobs(c, c.SourceDir+"/content/articles", c.TargetDir+"/content/images",
+			unpublishedDirs)
+		if err != nil {
+			return []error{err}
+		}
+
+		// Drop any articles whose sources were removed or renamed since the
+		// last build. Their rendered output is pruned once the build
+		// succeeds because their jobs are no longer enqueued.
+		if pruneArticles(&articles, sources) {
+			articlesChanged = true
+		}
+
 		for _, s := range sources {
 			source := s
 
+			// Articles are rendered after their images so that their
+			// placeholders are ready.
 			name := "article: " + filepath.Base(source)
-			c.AddJob(name, func() (bool, error) {
-				return renderArticle(ctx, c, source,
-					&articles, &articlesChanged, &articlesMu)
-			})
+			articleJobs = append(articleJobs, c.AddJobCtxAfter(name, imageJobs[filepath.Dir(source)],
+				func(ctx context.Context) (bool, error) {
+					return renderArticle(ctx, c, name, source,
+						&articles, &articlesChanged, &articlesMu)
+				}))
 		}
 	}
 
@@ -255,12 +420,18 @@ func build(c *modulir.Context) []error {
 		return []error{err}
 	}
 
+	pageImageJobs, err := addImageJobs(c, c.SourceDir+"/content/pages", c.TargetDir+"/content/images", nil)
+	if err != nil {
+		return []error{err}
+	}
+
 	//
 	// Pages (render each view)
 	//
 
 	var pagesChanged bool
 	var pagesMu sync.RWMutex
+	var pageJobs []*modulir.Job
 
 	{
 		opts := mfile.ReadDirOptions{
@@ -272,57 +443,86 @@ func build(c *modulir.Context) []error {
 			return []error{err}
 		}
 
+		// As with articles above.
+		if prunePages(&pages, sources) {
+			pagesChanged = true
+		}
+
 		for _, s := range sources {
 			source := s
 
+			// As with articles, pages are rendered after their images.
 			name := "page: " + filepath.Base(source)
-			c.AddJob(name, func() (bool, error) {
-				return renderPage(ctx, c, source,
-					&pages, &pagesChanged, &pagesMu)
-			})
+			pageJobs = append(pageJobs, c.AddJobCtxAfter(name, pageImageJobs[filepath.Dir(source)],
+				func(ctx context.Context) (bool, error) {
+					return renderPage(ctx, c, name, source,
+						&pages, &pagesChanged, &pagesMu)
+				}))
 		}
 	}
 
 	//
 	// Copy over remaining images to /content/images
 	//
-	if err := mfile.CopyFile(c, c.SourceDir+"/content/images/CoolsterCodes.png", c.TargetDir+"/content/images/CoolsterCodes.png"); err != nil {
-		return []error{err}
-	}
-	if err := mfile.CopyFile(c, c.SourceDir+"/content/images/favicon.png", c.TargetDir+"/content/images/favicon.png"); err != nil {
-		return []error{err}
+	for _, image := range []string{"CoolsterCodes.png", "favicon.png"} {
+		source := c.SourceDir + "/content/images/" + image
+		target := c.TargetDir + "/content/images/" + image
+
+		// Only copy when changed because every page loads these, so a copy
+		// would reload every page open in development.
+		if !c.Changed(source) && mfile.Exists(target) {
+			continue
+		}
+
+		if err := mfile.CopyFile(c, source, target); err != nil {
+			return []error{err}
+		}
 	}
 
 	//
+	// Article collection
 	//
-	//
-	// PHASE 2
-	//
-	//
+	// Runs once all articles are parsed to produce the article-derived data
+	// that other jobs depend on. Tags are only known after parsing, so jobs
+	// for individual tags are enqueued from here as well.
 	//
 
-	if errors := c.Wait(); errors != nil {
-		c.Log.Errorf("Cancelling next phase due to build errors")
-		return errors
-	}
+	var tagMap map[string][]*Article
+	var tagCount, topNTags, topMTags []TagCount
 
-	// Various sorts for anything that might need it.
-	//
-	// Some slices are sorted above when they're read in so that they can be
-	// compared against a current version.
-	{
+	articlesJob := c.AddJobAfter("articles", articleJobs, func() (bool, error) {
 		slices.SortFunc(articles, func(a, b *Article) int { return b.PublishedAt.Compare(a.PublishedAt) })
-	}
+
+		tagMap = getTagMap(articles)
+		tagCount = getAllTagCounts(tagMap)
+		topNTags, topMTags = getTopNAndMTags(tagCount, NTags, MTags)
+
+		for tag, articles := range tagMap {
+			name := "tag: " + tag
+			c.AddJobCtx(name, func(ctx context.Context) (bool, error) {
+				return renderTag(ctx, c, name,
+					tag,
+					articles,
+					articlesChanged)
+			})
+
+			name = "articles feed: " + tag
+			c.AddJobCtx(name, func(ctx context.Context) (bool, error) {
+				return renderArticlesFeed(ctx, c, name, articles, &tag, articlesChanged)
+			})
+		}
+
+		return false, nil
+	})
+
+	// Jobs that depend on all content, both articles and pages.
+	contentJobs := append(slices.Clone(pageJobs), articlesJob)
 
 	//
 	// Home
 	//
-
-	tagMap := getTagMap(articles)
-	tagCount := getAllTagCounts(tagMap)
-	topNTags, topMTags := getTopNAndMTags(tagCount, NTags, MTags)
 	{
-		c.AddJob("home", func() (bool, error) {
+		c.AddJobCtxAfter("home", []*modulir.Job{articlesJob}, func(ctx context.Context) (bool, error) {
 			return renderHome(ctx, c, articles,
 				articlesChanged, topNTags, topMTags)
 		})
@@ -332,19 +532,44 @@ func build(c *modulir.Context) []error {
 	// Tags
 	//
 	{
-		for tag, articles := range tagMap {
-			c.AddJob(tag, func() (bool, error) {
-				return renderTag(ctx, c,
-					tag,
-					articles,
-					articlesChanged)
-			})
-		}
+		c.AddJobCtxAfter("tags", []*modulir.Job{articlesJob}, func(ctx context.Context) (bool, error) {
+			return renderAllTags(ctx, c, tagCount, articlesChanged)
+		})
 	}
 
+	//
+	// Not found
+	//
 	{
-		c.AddJob("tags", func() (bool, error) {
-			return renderAllTags(ctx, c, tagCount, articlesChanged)
+		c.AddJobCtx("404", func(ctx context.Context) (bool, error) {
+			return renderNotFound(ctx, c)
+		})
+	}
+
+	//
+	// Feeds
+	//
+	{
+		c.AddJobCtxAfter("articles feed", []*modulir.Job{articlesJob}, func(ctx context.Context) (bool, error) {
+			return renderArticlesFeed(ctx, c, "articles feed", articles, nil, articlesChanged)
+		})
+
+		c.AddJobCtxAfter("articles json feed", []*modulir.Job{articlesJob}, func(ctx context.Context) (bool, error) {
+			return renderArticlesJSONFeed(ctx, c, articles, articlesChanged)
+		})
+	}
+
+	//
+	// Sitemap
+	//
+	{
+		c.AddJobCtxAfter("sitemap", contentJobs, func(ctx context.Context) (bool, error) {
+			return renderSitemap(ctx, c, "sitemap", articles, pages, tagMap,
+				articlesChanged || pagesChanged)
+		})
+
+		c.AddJobCtx("robots", func(ctx context.Context) (bool, error) {
+			return renderRobots(ctx, c)
 		})
 	}
 
@@ -355,8 +580,13 @@ func build(c *modulir.Context) []error {
 		indexFileName := "index.json"
 		srcPath := "./web/" + indexFileName
 		dstPath := contentDir + "/" + indexFileName
-		c.AddJob("index", func() (bool, error) {
-			return generateIndex(srcPath, dstPath, articles, pages)
+		c.AddJobAfter("index", contentJobs, func() (bool, error) {
+			executed, err := generateIndex(srcPath, dstPath, articles, pages,
+				articlesChanged || pagesChanged)
+			if executed && err == nil {
+				c.MarkWritten(dstPath)
+			}
+			return executed, err
 		})
 	}
 
@@ -384,6 +614,10 @@ type Article struct {
 	// rendered, and then added separately.
 	Content template.HTML `toml:"-"`
 
+	// Draft indicates that the article is a work in progress. Drafts are
+	// excluded from builds except when previewing drafts in development.
+	Draft bool `toml:"draft,omitempty"`
+
 	// This would be '/content/images/<slug>/
 	ImgDir string `toml:"-"`
 
@@ -396,9 +630,25 @@ type Article struct {
 	// Image is an optional image that may be included with an article.
 	Image string `toml:"image,omitempty"`
 
+	// ImageHeight and ImageWidth are the dimensions of Image in pixels, or
+	// zero if they couldn't be read. They're calculated rather than read
+	// from frontmatter.
+	ImageHeight int `toml:"-"`
+	ImageWidth  int `toml:"-"`
+
+	// ImagePlaceholder is a data URI of a tiny, blurry version of Image to
+	// show while it loads, or empty if it doesn't have one.
+	ImagePlaceholder template.URL `toml:"-"`
+
 	// PublishedAt is when the article was published.
 	PublishedAt time.Time `toml:"published_at" validate:"required"`
 
+	// Scheduled indicates that the article's PublishedAt is in the future. Like
+	// drafts, scheduled articles are excluded from builds except when
+	// previewing drafts in development. It's calculated rather than read from
+	// frontmatter.
+	Scheduled bool `toml:"-"`
+
 	// Slug is a unique identifier for the article that also helps determine
 	// where it's addressable by URL.
 	Slug string `toml:"-"`
@@ -450,6 +700,14 @@ type Page struct {
 	ImgDir string `toml:"-"`
 }
 
+// articleState is the part of an article's frontmatter that decides whether
+// it's published, kept by source so that it doesn't need to be parsed again
+// until the source changes (see getUnpublishedArticleDirs).
+type articleState struct {
+	Draft       bool
+	PublishedAt time.Time
+}
+
 type IndexEntry struct {
 	Href    string   `json:"href"`
 	Title   string   `json:"title"`
@@ -532,6 +790,29 @@ func getLocals(locals map[string]interface{}) map[string]interface{} {
 	return defaults
 }
 
+// Loads articles, pages, and template dependencies from Modulir's persistent
+// build cache. Anything not found is left as is.
+func loadCachedState(c *modulir.Context) error {
+	if _, err := c.LoadCached(cacheKeyArticles, &articles); err != nil {
+		return err
+	}
+
+	if _, err := c.LoadCached(cacheKeyArticleStates, &articleStates); err != nil {
+		return err
+	}
+
+	if _, err := c.LoadCached(cacheKeyDependencies, dependencies); err != nil {
+		return err
+	}
+
+	if _, err := c.LoadCached(cacheKeyPages, &pages); err != nil {
+		return err
+	}
+
+	c.Log.Debugf("Loaded %v article(s) and %v page(s) from cache", len(articles), len(pages))
+	return nil
+}
+
 func insertOrReplaceArticle(articles *[]*Article, article *Article) {
 	for i, a := range *articles {
 		if article.Slug == a.Slug {
@@ -543,6 +824,55 @@ func insertOrReplaceArticle(articles *[]*Article, article *Article) {
 	*articles = append(*articles, article)
 }
 
+// Removes the article with the given slug if it's present. Returns true if an
+// article was removed.
+func removeArticle(articles *[]*Article, slug string) bool {
+	for i, a := range *articles {
+		if a.Slug == slug {
+			*articles = slices.Delete(*articles, i, i+1)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Removes any articles whose slugs don't correspond to one of the given
+// sources. Returns true if any articles were removed.
+func pruneArticles(articles *[]*Article, sources []string) bool {
+	slugs := getSourceSlugs(sources)
+
+	numArticles := len(*articles)
+	*articles = slices.DeleteFunc(*articles, func(a *Article) bool {
+		_, ok := slugs[a.Slug]
+		return !ok
+	})
+
+	return len(*articles) != numArticles
+}
+
+// Removes any pages whose slugs don't correspond to one of the given sources.
+// Returns true if any pages were removed.
+func prunePages(pages *[]*Page, sources []string) bool {
+	slugs := getSourceSlugs(sources)
+
+	numPages := len(*pages)
+	*pages = slices.DeleteFunc(*pages, func(p *Page) bool {
+		_, ok := slugs[p.Slug]
+		return !ok
+	})
+
+	return len(*pages) != numPages
+}
+
+func getSourceSlugs(sources []string) map[string]struct{} {
+	slugs := make(map[string]struct{}, len(sources))
+	for _, source := range sources {
+		slugs[scommon.ExtractSlug(source)] = struct{}{}
+	}
+	return slugs
+}
+
 func insertOrReplacePage(pages *[]*Page, page *Page) {
 	for i, a := range *pages {
 		if page.Slug == a.Slug {
@@ -553,14 +883,22 @@ func insertOrReplacePage(pages *[]*Page, page *Page) {
 	*pages = append(*pages, page)
 }
 
-func renderArticle(ctx context.Context, c *modulir.Context, source string,
+func renderArticle(ctx context.Context, c *modulir.Context, job, source string,
 	articles *[]*Article, articlesChanged *bool, mu *sync.Mutex,
 ) (bool, error) {
 	sourceChanged := c.Changed(source)
 
+	// The images in the article, whose sizes decide which variants it offers
+	// and which dimensions it's rendered with.
+	imagesChanged := c.ChangedAny(dependencies.getDependencies(source)...)
+
 	sourceTmpl := scommon.HTML + "/article.tmpl.html"
 	htmlChanged := c.ChangedAny(dependencies.getDependencies(sourceTmpl)...)
-	if !sourceChanged && !htmlChanged {
+
+	// Also check for the rendered article because a source that's restored
+	// after its output was pruned may not look like it changed.
+	target := path.Join(c.TargetDir, scommon.ExtractSlug(source)+".html")
+	if !sourceChanged && !imagesChanged && !htmlChanged && mfile.Exists(target) {
 		return false, nil
 	}
 
@@ -578,7 +916,27 @@ func renderArticle(ctx context.Context, c *modulir.Context, source string,
 
 	// Define an ImgDir (for later processing) and set Image as full path
 	article.ImgDir = "/" + strings.Replace(relativeDir, "articles", "images", 1) + "/"
+	var imageSources []string
 	if article.Image != "" {
+		imageSource := path.Join(relativeDir, article.Image)
+		if !mfile.Exists(imageSource) {
+			return true, xerrors.Errorf("image '%s' of article '%s' doesn't exist", imageSource, source)
+		}
+		imageSources = append(imageSources, imageSource)
+
+		info, err := mimage.ReadInfo(imageSource)
+		if err != nil {
+			return true, err
+		}
+		article.ImageHeight = info.Height
+		article.ImageWidth = info.Width
+
+		placeholder, err := mimage.Placeholder(imageSource, getImageOptions())
+		if err != nil {
+			return true, err
+		}
+		article.ImagePlaceholder = template.URL(placeholder)
+
 		article.Image = filepath.Join(article.ImgDir, article.Image)
 	}
 	if article.YouTube != "" {
@@ -595,15 +953,48 @@ func renderArticle(ctx context.Context, c *modulir.Context, source string,
 		return true, err
 	}
 
+	article.Scheduled = article.PublishedAt.After(time.Now())
+
+	// Make sure that the article goes live (or loses its banner when
+	// previewing) on its own once its publish time arrives.
+	if article.Scheduled {
+		c.RebuildAt(article.PublishedAt)
+	}
+
+	// Drafts and articles scheduled for the future are left out of the build
+	// entirely unless we're previewing them. Make sure to remove any version
+	// that was rendered before the article was marked as such.
+	if (article.Draft || article.Scheduled) && !previewDrafts {
+		c.Log.Debugf("Skipping draft or scheduled article: %s", source)
+
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return true, xerrors.Errorf("error removing unpublished article: %w", err)
+		}
+
+		mu.Lock()
+		if removeArticle(articles, article.Slug) {
+			*articlesChanged = true
+		}
+		mu.Unlock()
+
+		return true, nil
+	}
+
+	images := &contentImages{imgDir: article.ImgDir, sourceDir: relativeDir, sources: imageSources}
 	content, err := mmarkdownext.Render(string(data), &mmarkdownext.RenderOptions{
 		TemplateData: map[string]interface{}{
 			"Ctx": ctx,
 		},
-		ImgDir: article.ImgDir,
+		ImgDir:           article.ImgDir,
+		ImgSourceDir:     relativeDir,
+		ImageSizes:       imageSizes,
+		ImagePlaceholder: images.placeholder,
+		ImageVariants:    images.variants,
 	})
 	if err != nil {
 		return true, xerrors.Errorf("error rendering markdown %v", err)
 	}
+	dependencies.setDependencies(ctx, c, source, images.sources)
 
 	content, footnotes, ok := strings.Cut(content, `<div class="footnotes">`)
 	if ok {
@@ -640,11 +1031,13 @@ func renderArticle(ctx context.Context, c *modulir.Context, source string,
 		"Article": article,
 	})
 
-	err = dependencies.renderGoTemplate(ctx, c, sourceTmpl, path.Join(c.TargetDir, article.Slug+".html"), locals)
+	err = dependencies.renderGoTemplate(ctx, c, sourceTmpl, target, locals)
 	if err != nil {
 		return true, err
 	}
 
+	c.AddOutput(job, target)
+
 	mu.Lock()
 	insertOrReplaceArticle(articles, &article)
 	*articlesChanged = true
@@ -691,7 +1084,7 @@ func renderHome(ctx context.Context, c *modulir.Context,
 		path.Join(c.TargetDir, "index.html"), locals)
 }
 
-func renderTag(ctx context.Context, c *modulir.Context,
+func renderTag(ctx context.Context, c *modulir.Context, job string,
 	tag string,
 	articles []*Article,
 	articlesChanged bool,
@@ -710,10 +1103,10 @@ func renderTag(ctx context.Context, c *modulir.Context,
 		"Articles": articles,
 	})
 
-	targetDir := path.Join(c.TargetDir, "tags")
+	target := path.Join(c.TargetDir, "tags", urlTag+".html")
+	c.AddOutput(job, target)
 
-	return true, dependencies.renderGoTemplate(ctx, c, sourceTmpl,
-		path.Join(targetDir, tagToURL(tag)+".html"), locals)
+	return true, dependencies.renderGoTemplate(ctx, c, sourceTmpl, target, locals)
 }
 
 func renderAllTags(ctx context.Context, c *modulir.Context,
@@ -734,14 +1127,566 @@ func renderAllTags(ctx context.Context, c *modulir.Context,
 		path.Join(c.TargetDir, "tags/index.html"), locals)
 }
 
-func renderPage(ctx context.Context, c *modulir.Context, source string,
+// Renders the page shown for paths that don't exist to /404.html, which is
+// served with a 404 status by both the development server and most hosts.
+func renderNotFound(ctx context.Context, c *modulir.Context) (bool, error) {
+	sourceTmpl := scommon.HTML + "/404.tmpl.html"
+	target := path.Join(c.TargetDir, "404.html")
+
+	htmlChanged := c.ChangedAny(dependencies.getDependencies(sourceTmpl)...)
+	if !htmlChanged && mfile.Exists(target) {
+		return false, nil
+	}
+
+	return true, dependencies.renderGoTemplate(ctx, c, sourceTmpl, target, getLocals(nil))
+}
+
+// Renders an Atom feed for the given articles, which are expected to already
+// be sorted most recent first. If tag is nil, the feed is the site-wide one at
+// /articles.atom. Otherwise it's a per-tag feed at /tags/<urltag>.atom.
+func renderArticlesFeed(_ context.Context, c *modulir.Context, job string,
+	articles []*Article, tag *string, articlesChanged bool,
+) (bool, error) {
+	name := "articles"
+	filename := "articles.atom"
+	title := "Articles" + scommon.TitleSuffix
+	alternate := conf.AbsoluteURL
+	if tag != nil {
+		urlTag := tagToURL(*tag)
+		name = "tags/" + urlTag
+		filename = "tags/" + urlTag + ".atom"
+		title = *tag + scommon.TitleSuffix
+		alternate = conf.AbsoluteURL + "/tags/" + urlTag
+	}
+
+	target := path.Join(c.TargetDir, filename)
+	c.AddOutput(job, target)
+
+	if !articlesChanged && mfile.Exists(target) {
+		return false, nil
+	}
+
+	feed := &matom.Feed{
+		Title: title,
+		ID:    "tag:" + scommon.AtomTag + ",2021:/" + name,
+		Links: []*matom.Link{
+			{Rel: "self", Type: "application/atom+xml", Href: conf.AbsoluteURL + "/" + filename},
+			{Rel: "alternate", Type: "text/html", Href: alternate},
+		},
+	}
+
+	if len(articles) > 0 {
+		feed.Updated = articles[0].PublishedAt
+	}
+
+	for i, article := range articles {
+		if i >= scommon.NumAtomEntries {
+			break
+		}
+
+		entry := &matom.Entry{
+			Title: article.Title,
+			Content: &matom.EntryContent{
+				Content: absolutizeURLs(string(article.Content), conf.AbsoluteURL),
+				Type:    "html",
+			},
+			Published: article.PublishedAt,
+			Updated:   article.PublishedAt,
+			Link:      &matom.Link{Href: conf.AbsoluteURL + "/" + article.Slug},
+			ID: "tag:" + scommon.AtomTag + "," +
+				article.PublishedAt.Format("2006-01-02") + ":" + article.Slug,
+			Author: &matom.Author{Name: scommon.AtomAuthorName, URI: conf.AbsoluteURL},
+		}
+
+		if article.Hook != "" {
+			entry.Summary = &matom.EntryContent{Content: string(article.Hook), Type: "html"}
+		}
+
+		for _, tag := range article.Tags {
+			entry.Categories = append(entry.Categories, &matom.Category{Term: tag})
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	var buf bytes.Buffer
+	if err := feed.Encode(&buf, "  "); err != nil {
+		return true, xerrors.Errorf("error encoding feed: %w", err)
+	}
+
+	if err := mfile.WriteFileAtomic(target, buf.Bytes(), 0o644); err != nil {
+		return true, xerrors.Errorf("error writing feed: %w", err)
+	}
+
+	c.MarkWritten(target)
+	return true, nil
+}
+
+// Renders a JSON Feed for all articles, which are expected to already be
+// sorted most recent first, to /feed.json.
+func renderArticlesJSONFeed(_ context.Context, c *modulir.Context,
+	articles []*Article, articlesChanged bool,
+) (bool, error) {
+	filename := "feed.json"
+
+	target := path.Join(c.TargetDir, filename)
+	if !articlesChanged && mfile.Exists(target) {
+		return false, nil
+	}
+
+	feed := &mjsonfeed.Feed{
+		Title:       "Articles" + scommon.TitleSuffix,
+		HomePageURL: conf.AbsoluteURL,
+		FeedURL:     conf.AbsoluteURL + "/" + filename,
+		Icon:        conf.AbsoluteURL + "/content/images/CoolsterCodes.png",
+		Language:    "en-US",
+		Authors: []*mjsonfeed.Author{
+			{Name: scommon.AtomAuthorName, URL: conf.AbsoluteURL},
+		},
+	}
+
+	for i, article := range articles {
+		if i >= scommon.NumAtomEntries {
+			break
+		}
+
+		item := &mjsonfeed.Item{
+			ID:            conf.AbsoluteURL + "/" + article.Slug,
+			URL:           conf.AbsoluteURL + "/" + article.Slug,
+			Title:         article.Title,
+			ContentHTML:   absolutizeURLs(string(article.Content), conf.AbsoluteURL),
+			Summary:       stripHTML(string(article.Hook)),
+			DatePublished: article.PublishedAt,
+			DateModified:  article.PublishedAt,
+			Tags:          article.Tags,
+		}
+
+		if article.Image != "" {
+			item.Image = conf.AbsoluteURL + article.Image
+		}
+
+		feed.Items = append(feed.Items, item)
+	}
+
+	var buf bytes.Buffer
+	if err := feed.Encode(&buf, "  "); err != nil {
+		return true, xerrors.Errorf("error encoding feed: %w", err)
+	}
+
+	if err := mfile.WriteFileAtomic(target, buf.Bytes(), 0o644); err != nil {
+		return true, xerrors.Errorf("error writing feed: %w", err)
+	}
+
+	c.MarkWritten(target)
+	return true, nil
+}
+
+// Renders a sitemap covering the home page, articles, pages, and tag pages.
+// Articles and pages include any images that were copied into their image
+// directories, and articles with a YouTube video include it as a video entry.
+func renderSitemap(_ context.Context, c *modulir.Context, job string,
+	articles []*Article, pages []*Page, tagMap map[string][]*Article,
+	sourcesChanged bool,
+) (bool, error) {
+	if !sourcesChanged && mfile.Exists(path.Join(c.TargetDir, "sitemap.xml")) {
+		return false, nil
+	}
+
+	var latest time.Time
+	if len(articles) > 0 {
+		latest = articles[0].PublishedAt
+	}
+
+	urls := []*msitemap.URL{
+		{Loc: conf.AbsoluteURL, LastMod: latest},
+		{Loc: conf.AbsoluteURL + "/tags/", LastMod: latest},
+	}
+
+	for _, article := range articles {
+		u := &msitemap.URL{
+			Loc:     conf.AbsoluteURL + "/" + article.Slug,
+			LastMod: article.PublishedAt,
+		}
+
+		images, err := getSitemapImages(c, article.ImgDir)
+		if err != nil {
+			return true, err
+		}
+		u.Images = images
+
+		if article.YouTube != "" {
+			u.Videos = append(u.Videos, &msitemap.Video{
+				ThumbnailLoc:    getYouTubeThumbnailLink(article.YouTube),
+				Title:           article.Title,
+				Description:     stripHTML(string(article.Hook)),
+				PlayerLoc:       article.YouTubeEmbed,
+				PublicationDate: article.PublishedAt,
+			})
+		}
+
+		urls = append(urls, u)
+	}
+
+	for _, page := range pages {
+		u := &msitemap.URL{
+			Loc: conf.AbsoluteURL + "/" + page.Slug,
+		}
+
+		images, err := getSitemapImages(c, page.ImgDir)
+		if err != nil {
+			return true, err
+		}
+		u.Images = images
+
+		urls = append(urls, u)
+	}
+
+	tags := make([]string, 0, len(tagMap))
+	for tag := range tagMap {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	for _, tag := range tags {
+		// Articles in the tag map are in the same (sorted) order as the
+		// articles they were built from, so the first is the most recent.
+		urls = append(urls, &msitemap.URL{
+			Loc:     conf.AbsoluteURL + "/tags/" + tagToURL(tag),
+			LastMod: tagMap[tag][0].PublishedAt,
+		})
+	}
+
+	filenames, err := msitemap.Write(c.TargetDir, conf.AbsoluteURL, urls)
+	if err != nil {
+		return true, err
+	}
+
+	// The number of sitemap files varies with the number of URLs, so record
+	// them so that any no longer needed are pruned.
+	for _, filename := range filenames {
+		target := path.Join(c.TargetDir, filename)
+		c.AddOutput(job, target)
+		c.MarkWritten(target)
+	}
+
+	c.Log.Debugf("Wrote sitemap with %v URL(s) to %v", len(urls), filenames)
+	return true, nil
+}
+
+// Produces sitemap image entries for every image found in the given image
+// directory (e.g. `/content/images/<slug>/`) of the target. Images have
+// already been copied over in phase 1, so the target is the easiest place to
+// look for them.
+func getSitemapImages(c *modulir.Context, imgDir string) ([]*msitemap.Image, error) {
+	entries, err := os.ReadDir(path.Join(c.TargetDir, imgDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error reading image directory %q: %w", imgDir, err)
+	}
+
+	// Resized variants are versions of images that are already listed.
+	variants := make(map[string]struct{})
+	for _, entry := range entries {
+		for _, width := range imageVariantWidths {
+			variants[mimage.VariantPath(entry.Name(), width)] = struct{}{}
+		}
+	}
+
+	var images []*msitemap.Image
+	for _, entry := range entries {
+		if entry.IsDir() || !isImageExt(strings.ToLower(filepath.Ext(entry.Name()))) {
+			continue
+		}
+		if _, ok := variants[entry.Name()]; ok {
+			continue
+		}
+
+		images = append(images, &msitemap.Image{
+			Loc: conf.AbsoluteURL + path.Join(imgDir, entry.Name()),
+		})
+	}
+
+	return images, nil
+}
+
+// Renders a robots.txt that allows everything and points crawlers to the
+// sitemap. It only depends on configuration, so it's only rendered once per
+// process (or again if it's gone missing).
+func renderRobots(_ context.Context, c *modulir.Context) (bool, error) {
+	target := path.Join(c.TargetDir, "robots.txt")
+	if !c.FirstRun && mfile.Exists(target) {
+		return false, nil
+	}
+
+	robots := "User-agent: *\n" +
+		"Allow: /\n" +
+		"\n" +
+		"Sitemap: " + conf.AbsoluteURL + "/sitemap.xml\n"
+
+	if err := mfile.WriteFileAtomic(target, []byte(robots), 0o644); err != nil {
+		return true, xerrors.Errorf("error writing robots.txt: %w", err)
+	}
+	c.MarkWritten(target)
+
+	return true, nil
+}
+
+// Matches the attributes of HTML tags that hold URLs.
+var urlAttrRE = regexp.MustCompile(`\b(href|src|srcset)="([^"]*)"`)
+
+// Makes root-relative URLs in the attributes of HTML content (like a rendered
+// article) absolute against base so that the content still works away from
+// the site, like in a feed reader.
+func absolutizeURLs(content, base string) string {
+	absolutize := func(u string) string {
+		if strings.HasPrefix(u, "/") && !strings.HasPrefix(u, "//") {
+			return base + u
+		}
+		return u
+	}
+
+	return urlAttrRE.ReplaceAllStringFunc(content, func(attr string) string {
+		matches := urlAttrRE.FindStringSubmatch(attr)
+		name, value := matches[1], matches[2]
+
+		if name != "srcset" {
+			return name + `="` + absolutize(value) + `"`
+		}
+
+		// Each candidate in a `srcset` is a URL followed by a descriptor.
+		candidates := strings.Split(value, ",")
+		for i, candidate := range candidates {
+			candidates[i] = absolutize(strings.TrimSpace(candidate))
+		}
+		return name + `="` + strings.Join(candidates, ", ") + `"`
+	})
+}
+
+var htmlTagRE = regexp.MustCompile(`<[^>]*>`)
+
+// Reduces a snippet of HTML (like a rendered hook) to plain text.
+func stripHTML(str string) string {
+	return strings.TrimSpace(html.UnescapeString(htmlTagRE.ReplaceAllString(str, "")))
+}
+
+// Adds a job for each image in the subdirectories of sourceDir that writes
+// resized variants of it next to its copy in targetDir (see
+// mfile.CopyDirectoryImages), which articles and pages then offer in a
+// `srcset`, and produces its placeholder. Subdirectories named in excludeDirs
+// are skipped. Returns the jobs keyed by the subdirectory containing their
+// image so that the articles and pages in it can be rendered after them.
+func addImageJobs(c *modulir.Context, sourceDir, targetDir string,
+	excludeDirs []string,
+) (map[string][]*modulir.Job, error) {
+	dirs, err := mfile.ReadDirWithOptions(c, sourceDir, &mfile.ReadDirOptions{ShowDirs: true})
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make(map[string][]*modulir.Job)
+
+	for _, dir := range dirs {
+		if slices.Contains(excludeDirs, filepath.Base(dir)) {
+			continue
+		}
+
+		files, err := mfile.ReadDirWithOptions(c, dir, &mfile.ReadDirOptions{IgnoreMDs: true})
+		if err != nil {
+			return nil, err
+		}
+
+		imageTargetDir := path.Join(targetDir, filepath.Base(dir))
+
+		for _, f := range files {
+			source := f
+			if !mimage.IsSupported(source) {
+				continue
+			}
+
+			name := "image: " + source
+			jobs[filepath.Clean(dir)] = append(jobs[filepath.Clean(dir)], c.AddJob(name, func() (bool, error) {
+				return renderImage(c, name, source, imageTargetDir)
+			}))
+		}
+	}
+
+	return jobs, nil
+}
+
+// Gets the names of the directories of articles that are left out of the
+// build because they're drafts or scheduled for the future (see
+// renderArticle) so that their images can be left out too. There are none
+// when previewing drafts.
+//
+// Only the frontmatter of sources that changed is parsed. The state of the
+// rest comes from articleStates, which is left holding only the given sources.
+func getUnpublishedArticleDirs(c *modulir.Context, sources []string) ([]string, error) {
+	if previewDrafts {
+		return nil, nil
+	}
+
+	states := make(map[string]*articleState, len(sources))
+	var dirs []string
+	for _, source := range sources {
+		// Make sure Changed comes first so that the source is always tracked.
+		state, ok := articleStates[source]
+		if c.Changed(source) || !ok {
+			var article Article
+			if _, err := mtoml.ParseFileFrontmatter(c, source, &article); err != nil {
+				return nil, xerrors.Errorf("error parsing frontmatter of '%s': %w", source, err)
+			}
+			state = &articleState{Draft: article.Draft, PublishedAt: article.PublishedAt}
+		}
+		states[source] = state
+
+		if state.Draft || state.PublishedAt.After(time.Now()) {
+			dirs = append(dirs, filepath.Base(filepath.Dir(source)))
+		}
+	}
+	articleStates = states
+
+	return dirs, nil
+}
+
+// Gets the options used to produce variants and placeholders of images.
+func getImageOptions() *mimage.Options {
+	return &mimage.Options{CacheDir: conf.ImageCache}
+}
+
+// Writes resized variants of the image at source into targetDir at each of
+// imageVariantWidths narrower than it, and produces its placeholder so that
+// it's ready by the time that the articles and pages using it are rendered
+// (see addImageJobs).
+// Both are cached in ImageCache by the hash of their source so that a fresh
+// target doesn't mean resizing every image again.
+func renderImage(c *modulir.Context, job, source, targetDir string) (bool, error) {
+	sourceChanged := c.Changed(source)
+
+	info, err := mimage.ReadInfo(source)
+	if err != nil {
+		return true, err
+	}
+
+	widths := info.VariantWidths(imageVariantWidths)
+
+	targets := make([]string, len(widths))
+	targetsExist := true
+	for i, width := range widths {
+		targets[i] = mimage.VariantPath(path.Join(targetDir, filepath.Base(source)), width)
+		targetsExist = targetsExist && mfile.Exists(targets[i])
+	}
+	c.AddOutput(job, targets...)
+
+	if !sourceChanged && targetsExist {
+		return false, nil
+	}
+
+	if _, err := mimage.WriteVariants(source, targetDir, widths, getImageOptions()); err != nil {
+		return true, err
+	}
+
+	if _, err := mimage.Placeholder(source, getImageOptions()); err != nil {
+		return true, err
+	}
+
+	c.MarkWritten(targets...)
+	return true, nil
+}
+
+// contentImages offers the variants and placeholders produced by renderImage
+// for the images in an article or page, and remembers the sources of those
+// images so that it can be rendered again when one of them changes.
+type contentImages struct {
+	// imgDir is the URL path that the images are served from.
+	imgDir string
+
+	// sourceDir is the directory containing the images' sources.
+	sourceDir string
+
+	// sources are the sources of images that were looked up, along with
+	// any others that the article or page depends on.
+	sources []string
+}
+
+// Gets the placeholder for the image at URL img for use as
+// mmarkdownext.RenderOptions.ImagePlaceholder.
+func (i *contentImages) placeholder(img string) (string, error) {
+	source, ok := i.source(img)
+	if !ok {
+		return "", nil
+	}
+
+	return mimage.Placeholder(source, getImageOptions())
+}
+
+// Gets the source of the image at URL img, if it's one that variants and
+// placeholders are produced for.
+func (i *contentImages) source(img string) (string, bool) {
+	name, ok := strings.CutPrefix(img, i.imgDir)
+	if !ok || !mimage.IsSupported(name) {
+		return "", false
+	}
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+
+	// Missing images are left for rendering to report.
+	source := path.Join(i.sourceDir, name)
+	if !mfile.Exists(source) {
+		return "", false
+	}
+
+	return source, true
+}
+
+// Gets variants for the image at URL img for use as
+// mmarkdownext.RenderOptions.ImageVariants.
+func (i *contentImages) variants(img string) ([]*mmarkdownext.ImageVariant, error) {
+	source, ok := i.source(img)
+	if !ok {
+		return nil, nil
+	}
+	i.sources = append(i.sources, source)
+
+	info, err := mimage.ReadInfo(source)
+	if err != nil {
+		return nil, err
+	}
+
+	widths := info.VariantWidths(imageVariantWidths)
+	if len(widths) < 1 {
+		return nil, nil
+	}
+
+	variants := make([]*mmarkdownext.ImageVariant, 0, len(widths)+1)
+	for _, width := range widths {
+		variants = append(variants, &mmarkdownext.ImageVariant{
+			URL:   mimage.VariantPath(img, width),
+			Width: width,
+		})
+	}
+
+	return append(variants, &mmarkdownext.ImageVariant{URL: img, Width: info.Width}), nil
+}
+
+func renderPage(ctx context.Context, c *modulir.Context, job, source string,
 	pages *[]*Page, pagesChanged *bool, mu *sync.RWMutex,
 ) (bool, error) {
 	sourceChanged := c.Changed(source)
 
+	// The images in the page, whose sizes decide which variants it offers and
+	// which dimensions it's rendered with.
+	imagesChanged := c.ChangedAny(dependencies.getDependencies(source)...)
+
 	sourceTmpl := scommon.HTML + "/page.tmpl.html"
 	htmlChanged := c.ChangedAny(dependencies.getDependencies(sourceTmpl)...)
-	if !sourceChanged && !htmlChanged {
+
+	// Also check for the rendered page because a source that's restored
+	// after its output was pruned may not look like it changed.
+	target := path.Join(c.TargetDir, scommon.ExtractSlug(source)+".html")
+	if !sourceChanged && !imagesChanged && !htmlChanged && mfile.Exists(target) {
 		return false, nil
 	}
 
@@ -765,26 +1710,34 @@ func renderPage(ctx context.Context, c *modulir.Context, source string,
 		return true, err
 	}
 
+	images := &contentImages{imgDir: page.ImgDir, sourceDir: relativeDir}
 	content, err := mmarkdownext.Render(string(data), &mmarkdownext.RenderOptions{
 		TemplateData: map[string]interface{}{
 			"Ctx": ctx,
 		},
-		ImgDir: page.ImgDir,
+		ImgDir:           page.ImgDir,
+		ImgSourceDir:     relativeDir,
+		ImageSizes:       imageSizes,
+		ImagePlaceholder: images.placeholder,
+		ImageVariants:    images.variants,
 	})
 	if err != nil {
 		return true, xerrors.Errorf("error rendering markdown %v", err)
 	}
+	dependencies.setDependencies(ctx, c, source, images.sources)
 	page.Content = template.HTML(content)
 
 	locals := getLocals(map[string]interface{}{
 		"Page": page,
 	})
 
-	err = dependencies.renderGoTemplate(ctx, c, sourceTmpl, path.Join(c.TargetDir, page.Slug+".html"), locals)
+	err = dependencies.renderGoTemplate(ctx, c, sourceTmpl, target, locals)
 	if err != nil {
 		return true, err
 	}
 
+	c.AddOutput(job, target)
+
 	mu.Lock()
 	insertOrReplacePage(pages, &page)
 	*pagesChanged = true
@@ -864,8 +1817,34 @@ func getYouTubeEmbedLink(link string) string {
 	return "https://www.youtube.com/embed/" + id
 }
 
-func generateIndex(srcPath, dstPath string, articles []*Article, pages []*Page) (bool, error) {
+// Gets a link to the high quality thumbnail that YouTube generates for every
+// video given a link to the video.
+func getYouTubeThumbnailLink(link string) string {
+	id := link[strings.LastIndex(link, "/")+1:]
+	return "https://img.youtube.com/vi/" + id + "/hqdefault.jpg"
+}
+
+// Returns true if the given canonical extension is one for an image that's
+// served on the site.
+func isImageExt(canonicalExt string) bool {
+	switch canonicalExt {
+	case ".gif", ".jpeg", ".jpg", ".png", ".svg", ".webp":
+		return true
+	}
+	return false
+}
+
+func generateIndex(srcPath, dstPath string, articles []*Article, pages []*Page,
+	sourcesChanged bool,
+) (bool, error) {
+	if !sourcesChanged && mfile.Exists(dstPath) {
+		return false, nil
+	}
+
+	// The index in the source tree is committed, so drafts and scheduled
+	// articles being previewed are only included in its copy in the target.
 	entries := map[string]IndexEntry{}
+	published := map[string]IndexEntry{}
 	for _, a := range articles {
 		entries[a.Slug] = IndexEntry{
 			Href:    a.Slug,
@@ -874,6 +1853,10 @@ func generateIndex(srcPath, dstPath string, articles []*Article, pages []*Page)
 			Tags:    a.Tags,
 			Img:     a.Image,
 		}
+
+		if !a.Draft && !a.Scheduled {
+			published[a.Slug] = entries[a.Slug]
+		}
 	}
 
 	for _, p := range pages {
@@ -882,45 +1865,30 @@ func generateIndex(srcPath, dstPath string, articles []*Article, pages []*Page)
 			Title:   p.Title,
 			Summary: p.Body,
 		}
+		published[p.Slug] = entries[p.Slug]
 	}
 
-	file, err := os.Create(srcPath)
-	if err != nil {
-		return false, xerrors.Errorf("error creating src file %s: %v", srcPath, err)
-	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", " ") // pretty-print
-	if err := encoder.Encode(entries); err != nil {
-		return false, xerrors.Errorf("error encoding %v", err)
+	if err := writeIndex(srcPath, published); err != nil {
+		return false, err
 	}
 
-	if err := copyFile(srcPath, dstPath); err != nil {
-		return false, xerrors.Errorf("error copying file %v", err)
+	if err := writeIndex(dstPath, entries); err != nil {
+		return false, err
 	}
 	return true, nil
 }
 
-func copyFile(src, dst string) error {
-	// Open the source file
-	sourceFile, err := os.Open(src)
+func writeIndex(path string, entries map[string]IndexEntry) error {
+	file, err := os.Create(path)
 	if err != nil {
-		return xerrors.Errorf("error creating src file %s: %v", src, err)
+		return xerrors.Errorf("error creating index file %s: %v", path, err)
 	}
-	defer sourceFile.Close() // Ensure the source file is closed
-
-	// Create the destination file
-	destinationFile, err := os.Create(dst)
-	if err != nil {
-		return xerrors.Errorf("error creating dst file %s: %v", dst, err)
-	}
-	defer destinationFile.Close() // Ensure the destination file is closed
+	defer file.Close()
 
-	// Copy the contents
-	_, err = io.Copy(destinationFile, sourceFile)
-	if err != nil {
-		return xerrors.Errorf("error copying file %v", err)
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", " ") // pretty-print
+	if err := encoder.Encode(entries); err != nil {
+		return xerrors.Errorf("error encoding %v", err)
 	}
 
 	return nil
@@ -69,3 +69,64 @@ func TestToURL(t *testing.T) {
 	require.Equal(t, "ballin-it-up", tagToURL("Ballin' it up"))
 	require.Equal(t, "hey", tagToURL("Hey!"))
 }
+
+func TestAbsolutizeURLs(t *testing.T) {
+	require.Equal(t,
+		`<a href="https://example.com/content/images/a.png"><img src="https://example.com/content/images/a.png" `+
+			`srcset="https://example.com/content/images/a-480w.png 480w, https://example.com/content/images/a.png 960w" /></a>`,
+		absolutizeURLs(`<a href="/content/images/a.png"><img src="/content/images/a.png" `+
+			`srcset="/content/images/a-480w.png 480w, /content/images/a.png 960w" /></a>`, "https://example.com"),
+	)
+
+	// Absolute, protocol-relative, and fragment URLs are left alone.
+	require.Equal(t,
+		`<a href="https://other.com/">a</a><a href="//cdn.com/b.js">b</a><a href="#c">c</a>`,
+		absolutizeURLs(`<a href="https://other.com/">a</a><a href="//cdn.com/b.js">b</a><a href="#c">c</a>`,
+			"https://example.com"),
+	)
+}
+
+func TestStripHTML(t *testing.T) {
+	require.Equal(t, "Quite the baller", stripHTML("Quite the <em>baller</em>"))
+	require.Equal(t, "Tom & Jerry", stripHTML(" Tom &amp; Jerry "))
+}
+
+func TestGetYouTubeThumbnailLink(t *testing.T) {
+	require.Equal(t, "https://img.youtube.com/vi/fbfHSW_qy_Y/hqdefault.jpg",
+		getYouTubeThumbnailLink("https://youtu.be/fbfHSW_qy_Y"))
+}
+
+func TestIsImageExt(t *testing.T) {
+	require.True(t, isImageExt(".jpeg"))
+	require.True(t, isImageExt(".png"))
+	require.False(t, isImageExt(".pdf"))
+	require.False(t, isImageExt(".md"))
+}
+
+func TestRemoveArticle(t *testing.T) {
+	articles := []*Article{{Slug: "a"}, {Slug: "b"}, {Slug: "c"}}
+
+	require.True(t, removeArticle(&articles, "b"))
+	require.Equal(t, []*Article{{Slug: "a"}, {Slug: "c"}}, articles)
+
+	require.False(t, removeArticle(&articles, "b"))
+	require.Len(t, articles, 2)
+}
+
+func TestPruneArticles(t *testing.T) {
+	articles := []*Article{{Slug: "a"}, {Slug: "b"}, {Slug: "c"}}
+	sources := []string{"content/articles/a/a.md", "content/articles/c/c.md"}
+
+	require.True(t, pruneArticles(&articles, sources))
+	require.Equal(t, []*Article{{Slug: "a"}, {Slug: "c"}}, articles)
+
+	require.False(t, pruneArticles(&articles, sources))
+	require.Len(t, articles, 2)
+}
+
+func TestPrunePages(t *testing.T) {
+	pages := []*Page{{Slug: "about"}, {Slug: "contact"}}
+
+	require.True(t, prunePages(&pages, []string{"content/pages/about/about.md"}))
+	require.Equal(t, []*Page{{Slug: "about"}}, pages)
+}
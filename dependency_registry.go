@@ -1,17 +1,20 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"html/template"
 	"io"
 	"os"
+	"path"
 	"regexp"
 	"sync"
 
 	"golang.org/x/xerrors"
 
 	"coolstercodes/modules/modulir"
+	"coolstercodes/modules/modulir/mminify"
 	"coolstercodes/modules/modulir/mtemplatemd"
 	"coolstercodes/modules/scommon"
 )
@@ -36,6 +39,30 @@ func NewDependencyRegistry() *DependencyRegistry {
 	}
 }
 
+// MarshalJSON encodes the registry's sources so that it can be persisted to
+// the build cache.
+func (r *DependencyRegistry) MarshalJSON() ([]byte, error) {
+	r.sourcesMu.RLock()
+	defer r.sourcesMu.RUnlock()
+
+	return json.Marshal(r.sources)
+}
+
+// UnmarshalJSON decodes sources persisted to the build cache, replacing any
+// that the registry currently has.
+func (r *DependencyRegistry) UnmarshalJSON(data []byte) error {
+	sources := make(map[string][]string)
+	if err := json.Unmarshal(data, &sources); err != nil {
+		return xerrors.Errorf("error decoding dependency registry: %w", err)
+	}
+
+	r.sourcesMu.Lock()
+	r.sources = sources
+	r.sourcesMu.Unlock()
+
+	return nil
+}
+
 func (r *DependencyRegistry) getDependencies(source string) []string {
 	r.sourcesMu.RLock()
 	defer r.sourcesMu.RUnlock()
@@ -74,16 +101,31 @@ func (r *DependencyRegistry) parseGoTemplate(baseTmpl *template.Template,
 func (r *DependencyRegistry) renderGoTemplate(ctx context.Context, c *modulir.Context,
 	source, target string, locals map[string]interface{},
 ) error {
-	file, err := os.Create(target)
-	if err != nil {
-		return xerrors.Errorf("error creating target file: %w", err)
+	// Render to memory first so that a job that's cancelled part way through
+	// doesn't leave a truncated file in the target directory.
+	var buf bytes.Buffer
+	if minifyHTML {
+		minifier := mminify.NewHTMLWriter(&buf)
+		if err := r.renderGoTemplateWriter(ctx, c, source, minifier, locals); err != nil {
+			return err
+		}
+		if err := minifier.Close(); err != nil {
+			return xerrors.Errorf("error minifying '%s': %w", target, err)
+		}
+	} else if err := r.renderGoTemplateWriter(ctx, c, source, &buf, locals); err != nil {
+		return err
+	}
+
+	if err := context.Cause(ctx); err != nil {
+		return xerrors.Errorf("error rendering '%s': %w", target, err)
 	}
-	defer file.Close()
 
-	writer := bufio.NewWriter(file)
-	defer writer.Flush()
+	if err := os.WriteFile(target, buf.Bytes(), 0o644); err != nil {
+		return xerrors.Errorf("error writing target file: %w", err)
+	}
+	c.MarkWritten(target)
 
-	return r.renderGoTemplateWriter(ctx, c, source, writer, locals)
+	return nil
 }
 
 func (r *DependencyRegistry) renderGoTemplateWriter(ctx context.Context, c *modulir.Context,
@@ -102,7 +144,15 @@ func (r *DependencyRegistry) renderGoTemplateWriter(ctx context.Context, c *modu
 		return xerrors.Errorf("error executing template: %w", err)
 	}
 
-	r.setDependencies(ctx, c, source, append(dependencies, includeMarkdownContainer.Dependencies...))
+	dependencies = append(dependencies, includeMarkdownContainer.Dependencies...)
+
+	// The asset manifest is only rewritten when an asset changes, and then
+	// every page needs to be rendered again to link to the new version.
+	if fingerprintAssets {
+		dependencies = append(dependencies, path.Join(c.TargetDir, "content", assetManifestFile))
+	}
+
+	r.setDependencies(ctx, c, source, dependencies)
 
 	return nil
 }
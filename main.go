@@ -1,9 +1,17 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
 	"fmt"
+	"io/fs"
 	"os"
+	"os/signal"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/joeshaw/envdecode"
 	"github.com/sirupsen/logrus"
@@ -11,6 +19,7 @@ import (
 	"golang.org/x/term"
 
 	"coolstercodes/modules/modulir"
+	"coolstercodes/modules/modulir/mlinkcheck"
 )
 
 //////////////////////////////////////////////////////////////////////////////
@@ -38,11 +47,51 @@ Starts the build loop that watches for local changes and runs
 when they're detected. A webserver is started on PORT (default
 5002).`),
 		Run: func(_ *cobra.Command, _ []string) {
+			setCopyAssets(assetOutputCopy)
+			fingerprintAssets = true
+			minifyHTML = true
 			modulir.Build(getModulirConfig(), build)
+
+			if !checkLinks(conf.LinkCheck) {
+				os.Exit(1)
+			}
 		},
 	}
 	rootCmd.AddCommand(buildCommand)
 
+	checkLinksCommand := &cobra.Command{
+		Use:   "check-links",
+		Short: "Check for broken internal links in the built site",
+		Long: strings.TrimSpace(`
+Checks every HTML file in TARGET_DIR (default ./public/) for links
+to pages, files, and anchors that don't exist, and exits with an
+error if any are found. Run build first.`),
+		Run: func(_ *cobra.Command, _ []string) {
+			if !checkLinks(linkCheckStrict) {
+				os.Exit(1)
+			}
+		},
+	}
+	rootCmd.AddCommand(checkLinksCommand)
+
+	checkExternalLinksCommand := &cobra.Command{
+		Use:   "check-external-links",
+		Short: "Check for broken external links in the built site",
+		Long: strings.TrimSpace(`
+Checks that every external link in the content of HTML files in
+TARGET_DIR (default ./public/) is reachable, and exits with an
+error if any aren't. Results are cached to EXTERNAL_LINK_CACHE so
+that reruns only check new and broken links, and
+EXTERNAL_LINK_OFFLINE=true reports from the cache without any
+network access. Run build first.`),
+		Run: func(_ *cobra.Command, _ []string) {
+			if !checkExternalLinks() {
+				os.Exit(1)
+			}
+		},
+	}
+	rootCmd.AddCommand(checkExternalLinksCommand)
+
 	loopCommand := &cobra.Command{
 		Use:   "loop",
 		Short: "Start build and serve loop",
@@ -50,6 +99,8 @@ when they're detected. A webserver is started on PORT (default
 Runs the build loop one time and places the result in TARGET_DIR
 (default ./public/).`),
 		Run: func(_ *cobra.Command, _ []string) {
+			previewDrafts = conf.CCEnv == ccEnvDevelopment
+			setCopyAssets(assetOutputSymlink)
 			modulir.BuildLoop(getModulirConfig(), build)
 		},
 	}
@@ -80,6 +131,25 @@ Runs the build loop one time and places the result in TARGET_DIR
 // very many places and can probably be refactored as a local if desired.
 var conf Conf
 
+// previewDrafts indicates that draft and scheduled articles should be rendered
+// (with a banner marking them as such) instead of being excluded from the
+// build. It's only set when looping in development.
+var previewDrafts bool
+
+// copyAssets indicates that JavaScripts and stylesheets should be copied into
+// the target directory instead of being symlinked (see Conf.AssetOutput).
+var copyAssets bool
+
+// fingerprintAssets indicates that JavaScripts and stylesheets should be
+// copied to names containing a hash of their contents, and that templates
+// should link to those copies. It's only set for builds so that looping in
+// development doesn't have to hash anything.
+var fingerprintAssets bool
+
+// minifyHTML indicates that rendered HTML pages should be minified. It's only
+// set for builds so that pages stay readable when looping in development.
+var minifyHTML bool
+
 //////////////////////////////////////////////////////////////////////////////
 //
 //
@@ -97,10 +167,72 @@ type Conf struct {
 	// It's used for things like Atom feeds and sending email.
 	AbsoluteURL string `env:"ABSOLUTE_URL,default=https://coolstercodes.com"`
 
+	// AssetOutput is how JavaScripts and stylesheets are written to the
+	// target directory: "copy" copies them (leaving out source maps and, when
+	// not in development, unminified duplicates) so that the target is
+	// self-contained, and "symlink" links them to their sources. Defaults to
+	// "copy" for `build` and "symlink" for `loop`.
+	AssetOutput string `env:"ASSET_OUTPUT"`
+
+	// BuildReport is a path to which a JSON report of every build (jobs,
+	// timings, errors, and changed sources) is written, or "-" for stdout.
+	// Useful for archiving in CI and checking for regressions.
+	BuildReport string `env:"BUILD_REPORT"`
+
+	// CachePath is a file to which Modulir persists its build cache between
+	// runs so that builds are incremental across restarts. It's kept outside
+	// of TargetDir so that it's never deployed with the site, and is restored
+	// along with the target directory in CI (see .github/workflows/ci.yaml).
+	CachePath string `env:"CACHE_PATH,default=.modulir_cache.json"`
+
+	// ChangeDetection is the strategy used to decide whether source files have
+	// changed between builds: "mtime", "hash", or "mtime_then_hash". The
+	// default only hashes files whose modification time or size changed,
+	// which keeps builds incremental where modification times aren't
+	// preserved (e.g. a fresh checkout in CI).
+	ChangeDetection string `env:"CHANGE_DETECTION,default=mtime_then_hash"`
+
+	// Compress writes gzip and brotli compressed variants of text files in
+	// the target directory as `.gz` and `.br` siblings after every build, and
+	// has the development server serve them to browsers that accept them,
+	// like our static host does. Useful for measuring real transfer sizes.
+	Compress bool `env:"COMPRESS,default=false"`
+
+	// CompressMinSize is the size in bytes below which files aren't worth
+	// compressing.
+	CompressMinSize int64 `env:"COMPRESS_MIN_SIZE,default=1024"`
+
 	// Concurrency is the number of build Goroutines that will be used to
 	// perform build work items.
 	Concurrency int `env:"CONCURRENCY,default=30"`
 
+	// ExternalLinkCache is a path to a file where the results of checking
+	// external links with `check-external-links` are cached between runs.
+	ExternalLinkCache string `env:"EXTERNAL_LINK_CACHE,default=.external_link_cache.json"`
+
+	// ExternalLinkOffline makes `check-external-links` report results from
+	// its cache without making any requests.
+	ExternalLinkOffline bool `env:"EXTERNAL_LINK_OFFLINE,default=false"`
+
+	// FailFast causes the first job to fail during a build to cancel all the
+	// others. Useful in CI where there's no point continuing a build that's
+	// already going to fail.
+	FailFast bool `env:"FAIL_FAST,default=false"`
+
+	// ImageCache is a directory where resized variants of images are cached
+	// by the hash of their source so that they're only generated again when
+	// the source changes, even for a fresh TARGET_DIR.
+	ImageCache string `env:"IMAGE_CACHE,default=.image_cache"`
+
+	// JobTimeout is the maximum amount of time that a single build job may
+	// run for before it's failed and its context cancelled.
+	JobTimeout time.Duration `env:"JOB_TIMEOUT,default=1m"`
+
+	// LinkCheck is how broken internal links found in the built site after
+	// `build` are handled: "off" doesn't check for them, "warn" logs them,
+	// and "strict" also fails the build.
+	LinkCheck string `env:"LINK_CHECK,default=warn"`
+
 	// Port is the port on which to serve HTTP when looping in development.
 	Port int `env:"PORT,default=5002"`
 
@@ -111,6 +243,11 @@ type Conf struct {
 	// TargetDir is the target location where the site will be built to.
 	TargetDir string `env:"TARGET_DIR,default=./public"`
 
+	// Trace is a path to which a Chrome trace of every build's timeline is
+	// written. Load it in Perfetto to see how jobs were laid out across
+	// workers when investigating a slow build.
+	Trace string `env:"TRACE"`
+
 	// Verbose is whether the program will print debug output as it's running.
 	Verbose bool `env:"VERBOSE,default=false"`
 }
@@ -126,9 +263,135 @@ type Conf struct {
 //////////////////////////////////////////////////////////////////////////////
 
 const (
+	// Name of the asset manifest written to the target's content directory
+	// when fingerprinting assets.
+	assetManifestFile = "assets.json"
+
 	ccEnvDevelopment = "development"
 )
 
+// The program's own Go sources, which are hashed into the build cache's key
+// (see getProgramHash).
+//
+//go:embed *.go go.mod go.sum modules
+var programSources embed.FS
+
+// Modes for writing assets to the target directory (see Conf.AssetOutput).
+const (
+	assetOutputCopy    = "copy"
+	assetOutputSymlink = "symlink"
+)
+
+// Patterns for assets that pages don't need, and which are left out when
+// copying assets into the target directory.
+var assetCopyExclude = []string{"*.map"}
+
+// Modes for checking links (see Conf.LinkCheck).
+const (
+	linkCheckOff    = "off"
+	linkCheckStrict = "strict"
+	linkCheckWarn   = "warn"
+)
+
+// Paths that pages link to that are served by Modulir itself rather than being
+// built into the target directory.
+var linkCheckIgnorePaths = []string{"/websocket.js"}
+
+// Sets copyAssets from Conf.AssetOutput, or from defaultMode if it's not set.
+// Exits if the mode isn't known.
+func setCopyAssets(defaultMode string) {
+	mode := conf.AssetOutput
+	if mode == "" {
+		mode = defaultMode
+	}
+
+	switch mode {
+	case assetOutputCopy:
+		copyAssets = true
+	case assetOutputSymlink:
+		copyAssets = false
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown asset output mode: %q\n", mode)
+		os.Exit(1)
+	}
+}
+
+// Checks the built site in TargetDir for broken internal links and logs any
+// that are found. Returns false if links couldn't be checked, or if any are
+// broken in strict mode.
+func checkLinks(mode string) bool {
+	log := getLog()
+
+	switch mode {
+	case linkCheckOff:
+		return true
+	case linkCheckStrict, linkCheckWarn:
+	default:
+		log.Errorf("Unknown link check mode: %q", mode)
+		return false
+	}
+
+	broken, err := mlinkcheck.Check(conf.TargetDir, &mlinkcheck.Options{
+		IgnorePaths: linkCheckIgnorePaths,
+	})
+	if err != nil {
+		log.Errorf("Error checking links: %v", err)
+		return false
+	}
+
+	for _, link := range broken {
+		if mode == linkCheckStrict {
+			log.Errorf("Broken link: %v", link)
+		} else {
+			log.Warnf("Broken link: %v", link)
+		}
+	}
+
+	log.Infof("Checked links; found %v broken", len(broken))
+	return mode != linkCheckStrict || len(broken) < 1
+}
+
+// Checks external links in the built site in TargetDir and logs any that are
+// broken. Returns false if links couldn't be checked or any are broken.
+//
+// An interrupt stops checking early, but results so far are still cached.
+func checkExternalLinks() bool {
+	log := getLog()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	links, err := mlinkcheck.CheckExternal(ctx, conf.TargetDir, &mlinkcheck.ExternalOptions{
+		CachePath: conf.ExternalLinkCache,
+		Offline:   conf.ExternalLinkOffline,
+	})
+	if err != nil {
+		log.Errorf("Error checking external links: %v", err)
+		return false
+	}
+
+	var numBroken, numUnchecked int
+	for _, link := range links {
+		switch {
+		case link.Result == nil:
+			numUnchecked++
+			log.Debugf("Unchecked external link: %s", link.URL)
+
+		case !link.Result.OK():
+			numBroken++
+			log.Errorf("Broken external link: %s (%v) in %s",
+				link.URL, link.Result, strings.Join(link.Files, ", "))
+		}
+	}
+
+	if numUnchecked > 0 {
+		log.Warnf("%v external link(s) not in cache weren't checked while offline", numUnchecked)
+	}
+
+	log.Infof("Checked %v external link(s); found %v broken", len(links)-numUnchecked, numBroken)
+	return numBroken < 1
+}
+
 func getLog() *logrus.Logger {
 	log := logrus.New()
 
@@ -145,12 +408,54 @@ func getLog() *logrus.Logger {
 // to a Modulir build loop.
 func getModulirConfig() *modulir.Config {
 	return &modulir.Config{
-		Concurrency: conf.Concurrency,
-		Log:         getLog(),
-		LogColor:    term.IsTerminal(int(os.Stdout.Fd())),
-		Port:        conf.Port,
-		SourceDir:   ".",
-		TargetDir:   conf.TargetDir,
-		Websocket:   conf.CCEnv == ccEnvDevelopment,
+		BuildReportPath: conf.BuildReport,
+		CacheKey: fmt.Sprintf("program=%s absolute_url=%s cc_env=%s fingerprint_assets=%v minify_html=%v preview_drafts=%v",
+			getProgramHash(), conf.AbsoluteURL, conf.CCEnv, fingerprintAssets, minifyHTML, previewDrafts),
+		CachePath:       conf.CachePath,
+		ChangeDetection: modulir.ChangeDetection(conf.ChangeDetection),
+		CompressMinSize: conf.CompressMinSize,
+		CompressOutputs: conf.Compress,
+		Concurrency:     conf.Concurrency,
+		FailFast:        conf.FailFast,
+		JobTimeout:      conf.JobTimeout,
+		Log:             getLog(),
+		LogColor:        term.IsTerminal(int(os.Stdout.Fd())),
+		Port:            conf.Port,
+		SourceDir:       ".",
+		TargetDir:       conf.TargetDir,
+		TracePath:       conf.Trace,
+		Websocket:       conf.CCEnv == ccEnvDevelopment,
 	}
 }
+
+// Hashes the program's sources (see programSources) along with the version of
+// Go that compiled them so that the build cache is invalidated by changes to
+// the program, but not by rebuilding the same program, which stamps a new VCS
+// revision into the executable. Exits if the sources can't be read.
+func getProgramHash() string {
+	hash := sha256.New()
+	hash.Write([]byte(runtime.Version()))
+
+	err := fs.WalkDir(programSources, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		data, err := programSources.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		hash.Write([]byte{0})
+		hash.Write([]byte(path))
+		hash.Write([]byte{0})
+		hash.Write(data)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error hashing program sources: %v\n", err)
+		os.Exit(1)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[0:16]
+}
@@ -0,0 +1,290 @@
+package modulir
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"coolstercodes/modules/modulir/internal/atomicfile"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Public
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// LoadCached decodes the value stored under key in the persistent build cache
+// into v. It returns false if there was no such value, which will always be
+// the case if no cache path was configured, no cache was found on startup, or
+// the cache found was invalidated.
+//
+// Values are only available once loaded from a previous process, so this is
+// typically called once during the first run of a build loop (see FirstRun).
+func (c *Context) LoadCached(key string, v interface{}) (bool, error) {
+	c.persistentValuesMu.Lock()
+	data, ok := c.persistentValues[key]
+	c.persistentValuesMu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, xerrors.Errorf("error decoding cached value %q: %w", key, err)
+	}
+
+	return true, nil
+}
+
+// StoreCached registers v to be saved under key in the persistent build cache
+// so that it can be reloaded with LoadCached by a future process. v is
+// encoded as JSON when the cache is saved after a successful build loop
+// finishes (i.e. after all jobs are done), so it's fine to register a pointer
+// to a value that's still being modified by jobs.
+//
+// Does nothing if no cache path was configured.
+func (c *Context) StoreCached(key string, v interface{}) {
+	if c.CachePath == "" {
+		return
+	}
+
+	c.persistentValuesMu.Lock()
+	c.persistentStores[key] = v
+	c.persistentValuesMu.Unlock()
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// The version of the cache file's format. Increment when making incompatible
+// changes to persistentCache.
+const persistentCacheVersion = 5
+
+// The structure of the persistent build cache that's written to disk.
+type persistentCache struct {
+	// Key identifies the build that produced the cache (see
+	// persistentCacheKey). A cache with a key that doesn't match is
+	// discarded.
+	Key string `json:"key"`
+
+	// Files are the states of files (modification times, sizes, and
+	// possibly hashes) from fileModTimeCache.
+	Files map[string]fileState `json:"files"`
+
+	// Incompressible are compressed variants of files in the target
+	// directory that were skipped because they wouldn't have been smaller,
+	// along with the states of the files when they were (see
+	// incompressibleFiles).
+	Incompressible map[string]fileState `json:"incompressible"`
+
+	// Outputs maps owners (usually jobs) to the files they produced in the
+	// target directory as of the last successful build (see AddOutput).
+	Outputs map[string][]string `json:"outputs"`
+
+	// ScheduledRebuilds are scheduled rebuild times (see RebuildAt) that
+	// hadn't elapsed yet when the cache was saved.
+	ScheduledRebuilds []time.Time `json:"scheduled_rebuilds"`
+
+	// TargetDir is the absolute path of the target directory that the cache
+	// describes. A cache for a different target directory is discarded.
+	TargetDir string `json:"target_dir"`
+
+	// Values are values registered by the user with StoreCached.
+	Values map[string]json.RawMessage `json:"values"`
+
+	// Version is the version of the cache file's format.
+	Version int `json:"version"`
+}
+
+// Loads the persistent cache from CachePath if one is configured and exists.
+// A missing, unreadable, or invalidated cache isn't an error -- it just means
+// that the build will start from scratch.
+func (c *Context) loadPersistentCache() {
+	if c.CachePath == "" {
+		return
+	}
+
+	data, err := os.ReadFile(c.CachePath)
+	if os.IsNotExist(err) {
+		c.Log.Debugf("No persistent cache found at '%s'", c.CachePath)
+		return
+	}
+	if err != nil {
+		c.Log.Warnf("Error reading persistent cache; ignoring: %v", err)
+		return
+	}
+
+	var cache persistentCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		c.Log.Warnf("Error decoding persistent cache; ignoring: %v", err)
+		return
+	}
+
+	if cache.Version != persistentCacheVersion {
+		c.Log.Infof("Persistent cache has an old format; ignoring")
+		return
+	}
+
+	targetDir, err := filepath.Abs(c.TargetDir)
+	if err != nil {
+		c.Log.Warnf("Error getting absolute target path; ignoring cache: %v", err)
+		return
+	}
+
+	// Outputs are relative to the target directory, so none of the cache
+	// applies to another one.
+	if cache.TargetDir != targetDir {
+		c.Log.Infof("Persistent cache was produced for a different target directory; ignoring")
+		return
+	}
+
+	// Outputs describe what's in the target directory rather than anything
+	// about the build that produced them, so they're loaded even if the rest
+	// of the cache is invalidated. Otherwise a change to the program would
+	// leave any files it no longer produces orphaned forever.
+	c.outputManifest.load(cache.Outputs)
+
+	key, err := c.persistentCacheKey()
+	if err != nil {
+		c.Log.Warnf("Error calculating persistent cache key; ignoring cache: %v", err)
+		return
+	}
+
+	if cache.Key != key {
+		c.Log.Infof("Persistent cache was produced by a different build; ignoring")
+		return
+	}
+
+	c.fileModTimeCache.load(cache.Files)
+	c.incompressible.load(cache.Incompressible)
+
+	// The cache lives outside the target directory, so the target may have
+	// been cleaned since (or never restored along with the cache in CI).
+	// Sources that haven't changed can't be trusted to still have outputs in
+	// that case, so build everything again.
+	if missing, ok := c.outputManifest.findMissing(c.TargetDir); ok {
+		c.Log.Infof("Output '%s' is missing from target directory; forcing full build", missing)
+		c.Forced = true
+	}
+
+	c.persistentValuesMu.Lock()
+	c.persistentValues = cache.Values
+	c.persistentValuesMu.Unlock()
+
+	// Any scheduled rebuilds that elapsed while we weren't running mean that
+	// some content has changed even though its sources haven't, so force a
+	// full build. Those that haven't are carried forward.
+	for _, t := range cache.ScheduledRebuilds {
+		if !t.After(time.Now()) {
+			c.Log.Infof("Scheduled rebuild time elapsed since last build; forcing full build")
+			c.Forced = true
+			continue
+		}
+		c.RebuildAt(t)
+	}
+
+	c.Log.Infof("Loaded persistent cache from '%s' (%v file(s))",
+		c.CachePath, len(cache.Files))
+}
+
+// Calculates a key which identifies the kind of build that produced a cache.
+// It's made up of the source directory and the user-provided CacheKey, which
+// is expected to change along with the program's code (see Config.CacheKey).
+//
+// The executable itself isn't hashed because it changes with every build of
+// it, even for the same code (say because a new VCS revision is stamped into
+// it), which would make the cache useless in CI.
+func (c *Context) persistentCacheKey() (string, error) {
+	sourceDir, err := filepath.Abs(c.SourceDir)
+	if err != nil {
+		return "", xerrors.Errorf("error getting absolute source path: %w", err)
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(sourceDir))
+	hash.Write([]byte{0})
+	hash.Write([]byte(c.CacheKey))
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Saves the persistent cache to CachePath if one is configured. Should only
+// be called after a successful build so that sources of failed jobs aren't
+// recorded as up to date.
+func (c *Context) savePersistentCache() error {
+	if c.CachePath == "" {
+		return nil
+	}
+
+	key, err := c.persistentCacheKey()
+	if err != nil {
+		return err
+	}
+
+	targetDir, err := filepath.Abs(c.TargetDir)
+	if err != nil {
+		return xerrors.Errorf("error getting absolute target path: %w", err)
+	}
+
+	cache := persistentCache{
+		Key:            key,
+		Files:          c.fileModTimeCache.snapshot(),
+		Incompressible: c.incompressible.snapshot(),
+		Outputs:        c.outputManifest.snapshot(),
+		TargetDir:      targetDir,
+		Values:         make(map[string]json.RawMessage),
+		Version:        persistentCacheVersion,
+	}
+
+	c.scheduledRebuildsMu.Lock()
+	cache.ScheduledRebuilds = append([]time.Time(nil), c.scheduledRebuilds...)
+	c.scheduledRebuildsMu.Unlock()
+
+	c.persistentValuesMu.Lock()
+	defer c.persistentValuesMu.Unlock()
+
+	// Carry forward any loaded values that haven't been replaced by newly
+	// stored ones.
+	for key, data := range c.persistentValues {
+		cache.Values[key] = data
+	}
+
+	for key, v := range c.persistentStores {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return xerrors.Errorf("error encoding cached value %q: %w", key, err)
+		}
+		cache.Values[key] = data
+	}
+
+	data, err := json.Marshal(&cache)
+	if err != nil {
+		return xerrors.Errorf("error encoding persistent cache: %w", err)
+	}
+
+	// Written atomically so that an interrupted write never leaves a corrupt
+	// cache behind.
+	if err := atomicfile.Write(c.CachePath, data, 0o600); err != nil {
+		return xerrors.Errorf("error writing persistent cache: %w", err)
+	}
+
+	c.Log.Debugf("Saved persistent cache to '%s'", c.CachePath)
+	return nil
+}
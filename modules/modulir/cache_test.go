@@ -0,0 +1,166 @@
+package modulir
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestPersistentCache(t *testing.T) {
+	dir := t.TempDir()
+	cachePath := filepath.Join(dir, "cache.json")
+
+	source := filepath.Join(dir, "source.md")
+	assert.NoError(t, os.WriteFile(source, []byte("hello"), 0o600))
+
+	newContext := func(cacheKey string) *Context {
+		return NewContext(&Args{
+			CacheKey:  cacheKey,
+			CachePath: cachePath,
+			Log:       &Logger{Level: LevelWarn},
+			SourceDir: dir,
+		})
+	}
+
+	type value struct {
+		Name string
+	}
+
+	scheduledAt := time.Now().Add(1 * time.Hour)
+
+	{
+		c := newContext("key")
+		c.loadPersistentCache()
+
+		assert.True(t, c.Changed(source))
+		c.RebuildAt(scheduledAt)
+
+		v := &value{}
+		c.StoreCached("value", v)
+
+		// Modified after storing, but before save, which is fine.
+		v.Name = "stored"
+
+		assert.NoError(t, c.savePersistentCache())
+	}
+
+	// A new context with the same key picks up where the last left off.
+	{
+		c := newContext("key")
+		c.loadPersistentCache()
+
+		assert.False(t, c.Changed(source))
+
+		var v value
+		ok, err := c.LoadCached("value", &v)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "stored", v.Name)
+
+		next, ok := c.nextScheduledRebuild()
+		assert.True(t, ok)
+		assert.True(t, scheduledAt.Equal(next))
+	}
+
+	// A context with a different key discards the cache.
+	{
+		c := newContext("other")
+		c.loadPersistentCache()
+
+		assert.True(t, c.Changed(source))
+
+		var v value
+		ok, err := c.LoadCached("value", &v)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	}
+}
+
+func TestPersistentCacheElapsedScheduledRebuild(t *testing.T) {
+	dir := t.TempDir()
+
+	c := NewContext(&Args{
+		CachePath: filepath.Join(dir, "cache.json"),
+		Log:       &Logger{Level: LevelWarn},
+		SourceDir: dir,
+	})
+
+	// Set directly because RebuildAt ignores times in the past.
+	c.scheduledRebuilds = []time.Time{time.Now().Add(-1 * time.Minute)}
+	assert.NoError(t, c.savePersistentCache())
+
+	c = NewContext(&Args{
+		CachePath: filepath.Join(dir, "cache.json"),
+		Log:       &Logger{Level: LevelWarn},
+		SourceDir: dir,
+	})
+	c.loadPersistentCache()
+
+	assert.True(t, c.Forced)
+
+	_, ok := c.nextScheduledRebuild()
+	assert.False(t, ok)
+}
+
+func TestPersistentCacheTargetDir(t *testing.T) {
+	dir := t.TempDir()
+	cachePath := filepath.Join(dir, "cache.json")
+
+	source := filepath.Join(dir, "source.md")
+	assert.NoError(t, os.WriteFile(source, []byte("hello"), 0o600))
+
+	newContext := func(targetDir string) *Context {
+		return NewContext(&Args{
+			CachePath: cachePath,
+			Log:       &Logger{Level: LevelWarn},
+			SourceDir: dir,
+			TargetDir: targetDir,
+		})
+	}
+
+	targetDir := filepath.Join(dir, "public")
+	target := filepath.Join(targetDir, "source.html")
+	assert.NoError(t, os.MkdirAll(targetDir, 0o755))
+	assert.NoError(t, os.WriteFile(target, []byte("hello"), 0o600))
+
+	{
+		c := newContext(targetDir)
+		c.loadPersistentCache()
+
+		assert.True(t, c.Changed(source))
+		c.AddOutput("source", target)
+		c.outputManifest.finish()
+
+		assert.NoError(t, c.savePersistentCache())
+	}
+
+	// The same target directory with all of its outputs uses the cache.
+	{
+		c := newContext(targetDir)
+		c.loadPersistentCache()
+
+		assert.False(t, c.Forced)
+		assert.False(t, c.Changed(source))
+	}
+
+	// A different target directory discards it.
+	{
+		c := newContext(filepath.Join(dir, "other"))
+		c.loadPersistentCache()
+
+		assert.True(t, c.Changed(source))
+	}
+
+	// A target directory that's missing outputs forces a full build.
+	{
+		assert.NoError(t, os.Remove(target))
+
+		c := newContext(targetDir)
+		c.loadPersistentCache()
+
+		assert.True(t, c.Forced)
+	}
+}
@@ -0,0 +1,346 @@
+package modulir
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"golang.org/x/xerrors"
+
+	"coolstercodes/modules/modulir/internal/atomicfile"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Extensions of files in TargetDir that are precompressed when CompressOutputs
+// is on. Other types are either already compressed (like images) or rarely
+// served.
+var compressibleExts = map[string]struct{}{
+	".atom": {},
+	".css":  {},
+	".html": {},
+	".js":   {},
+	".json": {},
+	".map":  {},
+	".svg":  {},
+	".txt":  {},
+	".xml":  {},
+}
+
+// compressedEncoding is a content encoding for which precompressed variants
+// of files are written as siblings with an extra extension (e.g.
+// `tailwind.css.gz`).
+type compressedEncoding struct {
+	// Encoding is the encoding's name in Accept-Encoding and
+	// Content-Encoding headers.
+	Encoding string
+
+	// Ext is the extension appended to the names of compressed files.
+	Ext string
+
+	// newWriter returns a writer that compresses into w.
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+// Encodings for which precompressed variants are written, in order of
+// preference when serving.
+var compressedEncodings = []*compressedEncoding{
+	{
+		Encoding: "br",
+		Ext:      ".br",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			// The best levels (10 and 11) are an order of magnitude slower
+			// in this encoder for only a few percent smaller output.
+			return brotli.NewWriterLevel(w, 9), nil
+		},
+	},
+	{
+		Encoding: "gzip",
+		Ext:      ".gz",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, gzip.BestCompression)
+		},
+	},
+}
+
+// Writes compressed variants of every compressible file in TargetDir that's
+// at least CompressMinSize bytes as siblings, one job per file, and waits for
+// them to finish. It's meant to run after all other jobs in a build are done.
+//
+// Variants are only rewritten when they're older than the file they were
+// compressed from, so only files that changed are compressed again. The same
+// goes for variants that were skipped because they wouldn't have been smaller
+// (see incompressibleFiles). Variants whose file no longer exists are removed.
+//
+// Directories that are symlinks are skipped so that variants are never written
+// outside of TargetDir, but symlinks to files are followed.
+func (c *Context) compressOutputs() []error {
+	var sources []string
+
+	err := filepath.WalkDir(c.TargetDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if path != c.TargetDir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		// A variant of a file that was removed.
+		if original, encoding := compressedOriginal(path); encoding != nil {
+			if _, err := os.Stat(original); os.IsNotExist(err) {
+				if err := os.Remove(path); err != nil {
+					return xerrors.Errorf("error removing stale compressed file: %w", err)
+				}
+				c.Log.Debugf("Removed stale compressed file: %s", path)
+			}
+			return nil
+		}
+
+		if _, ok := compressibleExts[filepath.Ext(path)]; !ok {
+			return nil
+		}
+
+		sources = append(sources, path)
+		return nil
+	})
+	if err != nil {
+		return []error{xerrors.Errorf("error walking target directory: %w", err)}
+	}
+
+	// Forget about skipped variants of files that no longer exist.
+	variants := make(map[string]struct{}, len(sources)*len(compressedEncodings))
+	for _, source := range sources {
+		for _, encoding := range compressedEncodings {
+			variants[relTargetPath(c.TargetDir, source+encoding.Ext)] = struct{}{}
+		}
+	}
+	c.incompressible.retain(variants)
+
+	for _, s := range sources {
+		source := s
+
+		name := "compress: " + source
+		c.AddJob(name, func() (bool, error) {
+			return compressFile(c.TargetDir, source, c.CompressMinSize, c.incompressible)
+		})
+	}
+
+	return c.Wait()
+}
+
+// Writes compressed variants of the file at source if they're missing or
+// older than it. Files smaller than minSize aren't compressed, and variants
+// that wouldn't be smaller than source aren't kept, which is recorded in
+// incompressible so that they're not tried again until source changes.
+func compressFile(targetDir, source string, minSize int64,
+	incompressible *incompressibleFiles,
+) (bool, error) {
+	// Stat rather than use the walk's entry so that symlinks are followed.
+	info, err := os.Stat(source)
+	if err != nil {
+		return false, xerrors.Errorf("error checking file: %w", err)
+	}
+
+	if !info.Mode().IsRegular() || info.Size() < minSize {
+		return false, nil
+	}
+
+	state := fileState{ModTime: info.ModTime(), Size: info.Size()}
+
+	var stale []*compressedEncoding
+	for _, encoding := range compressedEncodings {
+		target := source + encoding.Ext
+
+		compressedInfo, err := os.Stat(target)
+		if err == nil && !compressedInfo.ModTime().Before(info.ModTime()) {
+			continue
+		}
+
+		if incompressible.skipped(relTargetPath(targetDir, target), state) {
+			continue
+		}
+
+		stale = append(stale, encoding)
+	}
+
+	if len(stale) < 1 {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return false, xerrors.Errorf("error reading file: %w", err)
+	}
+
+	for _, encoding := range stale {
+		var buf bytes.Buffer
+
+		w, err := encoding.newWriter(&buf)
+		if err != nil {
+			return true, xerrors.Errorf("error initializing %s writer: %w", encoding.Encoding, err)
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return true, xerrors.Errorf("error compressing with %s: %w", encoding.Encoding, err)
+		}
+
+		if err := w.Close(); err != nil {
+			return true, xerrors.Errorf("error compressing with %s: %w", encoding.Encoding, err)
+		}
+
+		target := source + encoding.Ext
+		relTarget := relTargetPath(targetDir, target)
+
+		// Not worth serving, but remove any previous variant so that it's
+		// not served stale.
+		if buf.Len() >= len(data) {
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return true, xerrors.Errorf("error removing compressed file: %w", err)
+			}
+			incompressible.record(relTarget, state)
+			continue
+		}
+
+		if err := atomicfile.Write(target, buf.Bytes(), 0o644); err != nil {
+			return true, xerrors.Errorf("error writing compressed file: %w", err)
+		}
+		incompressible.forget(relTarget)
+	}
+
+	return true, nil
+}
+
+// Returns the path of the file that the file at path is a compressed variant
+// of along with its encoding, or a nil encoding if it's not a compressed
+// variant of a compressible file.
+func compressedOriginal(path string) (string, *compressedEncoding) {
+	for _, encoding := range compressedEncodings {
+		original, ok := strings.CutSuffix(path, encoding.Ext)
+		if !ok {
+			continue
+		}
+
+		if _, ok := compressibleExts[filepath.Ext(original)]; ok {
+			return original, encoding
+		}
+	}
+
+	return "", nil
+}
+
+// Returns path relative to targetDir, or path unchanged if it can't be made
+// relative.
+func relTargetPath(targetDir, path string) string {
+	rel, err := filepath.Rel(targetDir, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// incompressibleFiles remembers compressed variants that were skipped because
+// they wouldn't have been smaller than the files they were compressed from,
+// along with the states of those files at the time. Unlike for variants that
+// were written, there's nothing in TargetDir to compare a file's modification
+// time against, so without a record incompressible files would be compressed
+// again on every build.
+//
+// It's saved along with the persistent cache so that the record survives
+// between processes.
+type incompressibleFiles struct {
+	mu sync.Mutex
+
+	// states maps paths of skipped variants relative to TargetDir to the
+	// states of the files they were compressed from.
+	states map[string]fileState
+}
+
+// newIncompressibleFiles returns a new incompressibleFiles.
+func newIncompressibleFiles() *incompressibleFiles {
+	return &incompressibleFiles{states: make(map[string]fileState)}
+}
+
+// forget removes any record of the variant at relPath being skipped.
+func (f *incompressibleFiles) forget(relPath string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.states, relPath)
+}
+
+// load replaces records with those loaded from the persistent cache.
+func (f *incompressibleFiles) load(states map[string]fileState) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.states = make(map[string]fileState, len(states))
+	for relPath, state := range states {
+		f.states[relPath] = state
+	}
+}
+
+// record notes that the variant at relPath was skipped when its file was in
+// the given state.
+func (f *incompressibleFiles) record(relPath string, state fileState) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.states[relPath] = state
+}
+
+// retain forgets about all variants except for those in relPaths.
+func (f *incompressibleFiles) retain(relPaths map[string]struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for relPath := range f.states {
+		if _, ok := relPaths[relPath]; !ok {
+			delete(f.states, relPath)
+		}
+	}
+}
+
+// skipped returns whether the variant at relPath was skipped when its file
+// was last compressed, and the file hasn't changed since.
+func (f *incompressibleFiles) skipped(relPath string, state fileState) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lastState, ok := f.states[relPath]
+	return ok && lastState.Size == state.Size && lastState.ModTime.Equal(state.ModTime)
+}
+
+// snapshot returns a copy of the records suitable for saving to the
+// persistent cache.
+func (f *incompressibleFiles) snapshot() map[string]fileState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	states := make(map[string]fileState, len(f.states))
+	for relPath, state := range f.states {
+		states[relPath] = state
+	}
+	return states
+}
@@ -0,0 +1,136 @@
+package modulir
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCompressOutputs(t *testing.T) {
+	dir := t.TempDir()
+
+	css := []byte(strings.Repeat("body { color: red; }\n", 100))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "main.css"), css, 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "small.js"), []byte("alert(1);"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "image.png"), css, 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, ".cache.json"), css, 0o600))
+
+	// Random data doesn't compress, so no variants of it are kept.
+	random := make([]byte, 2048)
+	_, err := rand.Read(random)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "random.txt"), random, 0o600))
+
+	// A variant of a file that no longer exists.
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "removed.css.gz"), []byte("stale"), 0o600))
+
+	// Directories that are symlinks aren't followed, but files are.
+	linkedDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(linkedDir, "linked.css"), css, 0o600))
+	assert.NoError(t, os.Symlink(linkedDir, filepath.Join(dir, "linked")))
+	assert.NoError(t, os.Symlink(filepath.Join(linkedDir, "linked.css"),
+		filepath.Join(dir, "linked-file.css")))
+
+	c := NewContext(&Args{
+		CompressMinSize: 1024,
+		Log:             &Logger{Level: LevelWarn},
+		Pool:            NewPool(&Logger{Level: LevelWarn}, 2),
+		TargetDir:       dir,
+	})
+
+	compress := func() int {
+		c.ResetBuild()
+		c.StartRound()
+		assert.Empty(t, c.compressOutputs())
+		c.Pool.Wait()
+		return len(c.Stats.JobsExecuted)
+	}
+
+	assert.Equal(t, 3, compress())
+
+	for _, name := range []string{"main.css", "linked-file.css"} {
+		assert.Equal(t, css, readGzip(t, filepath.Join(dir, name+".gz")))
+		assert.Equal(t, css, readBrotli(t, filepath.Join(dir, name+".br")))
+	}
+
+	for _, name := range []string{
+		"small.js.gz", "image.png.gz", ".cache.json.gz",
+		"random.txt.gz", "random.txt.br",
+		"removed.css.gz", "linked/linked.css.gz",
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		assert.True(t, os.IsNotExist(err), "expected %s not to exist", name)
+	}
+
+	// Nothing changed, so nothing is compressed again, including the file
+	// whose variants weren't worth keeping.
+	assert.Equal(t, 0, compress())
+
+	// Which is remembered across processes.
+	{
+		c.CachePath = filepath.Join(t.TempDir(), "cache.json")
+		assert.NoError(t, c.savePersistentCache())
+
+		loaded := NewContext(&Args{CachePath: c.CachePath, Log: c.Log, TargetDir: dir})
+		loaded.loadPersistentCache()
+		info, err := os.Stat(filepath.Join(dir, "random.txt"))
+		assert.NoError(t, err)
+		state := fileState{ModTime: info.ModTime(), Size: info.Size()}
+		assert.True(t, loaded.incompressible.skipped("random.txt.br", state))
+		assert.True(t, loaded.incompressible.skipped("random.txt.gz", state))
+	}
+
+	// Until it changes.
+	random = append(random, 0)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "random.txt"), random, 0o600))
+	assert.Equal(t, 1, compress())
+
+	// Records of files that no longer exist are dropped.
+	assert.NoError(t, os.Remove(filepath.Join(dir, "random.txt")))
+	assert.Equal(t, 0, compress())
+	assert.Empty(t, c.incompressible.snapshot())
+
+	// A file that's changed since it was last compressed.
+	css = append(css, []byte("p { color: blue; }\n")...)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "main.css"), css, 0o600))
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "main.css"), future, future))
+
+	assert.Equal(t, 1, compress())
+	assert.Equal(t, css, readGzip(t, filepath.Join(dir, "main.css.gz")))
+	assert.Equal(t, css, readBrotli(t, filepath.Join(dir, "main.css.br")))
+}
+
+func readBrotli(t *testing.T, path string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	decompressed, err := io.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+	assert.NoError(t, err)
+	return decompressed
+}
+
+func readGzip(t *testing.T, path string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+
+	decompressed, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	return decompressed
+}
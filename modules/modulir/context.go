@@ -1,8 +1,14 @@
 package modulir
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
@@ -22,20 +28,47 @@ import (
 
 // Args are the set of arguments accepted by NewContext.
 type Args struct {
-	Concurrency int
-	Log         LoggerInterface
-	LogColor    bool
-	Pool        *Pool
-	Port        int
-	SourceDir   string
-	TargetDir   string
-	Watcher     *fsnotify.Watcher
-	Websocket   bool
+	BuildReportPath string
+	CacheKey        string
+	CachePath       string
+	ChangeDetection ChangeDetection
+	CompressMinSize int64
+	CompressOutputs bool
+	Concurrency     int
+	Log             LoggerInterface
+	LogColor        bool
+	Pool            *Pool
+	Port            int
+	SourceDir       string
+	TargetDir       string
+	TracePath       string
+	Watcher         *fsnotify.Watcher
+	Websocket       bool
 }
 
 // Context contains useful state that can be used by a user-provided build
 // function.
 type Context struct {
+	// BuildReportPath is a path to which a JSON build report is written after
+	// every build loop, or "-" for stdout. If empty, no report is written.
+	BuildReportPath string
+
+	// CacheKey is an arbitrary string that identifies the kind of build being
+	// run. A persistent cache produced with a different key is discarded.
+	CacheKey string
+
+	// CachePath is the path to a file where the build cache is persisted
+	// between processes. If empty, the cache lives in memory only.
+	CachePath string
+
+	// CompressMinSize is the size in bytes below which files aren't
+	// compressed by CompressOutputs.
+	CompressMinSize int64
+
+	// CompressOutputs causes compressed variants of text files in TargetDir
+	// to be written alongside them after every successful build.
+	CompressOutputs bool
+
 	// Concurrency is the number of concurrent workers to run during the build
 	// step.
 	Concurrency int
@@ -89,6 +122,10 @@ type Context struct {
 	// TargetDir is the directory where the site will be built to.
 	TargetDir string
 
+	// TracePath is a path to which a Chrome trace of the build's timeline is
+	// written after every build loop. If empty, no trace is written.
+	TracePath string
+
 	// Watcher is a file system watcher that picks up changes to source files
 	// and restarts the build loop.
 	Watcher *fsnotify.Watcher
@@ -99,12 +136,71 @@ type Context struct {
 	// Defaults to false.
 	Websocket bool
 
+	// buildCancel cancels buildCtx.
+	buildCancel context.CancelCauseFunc
+
+	// buildCtx is the context for the current build loop. Jobs' contexts
+	// derive from it.
+	buildCtx context.Context
+
+	// buildCtxMu synchronizes access to buildCtx and buildCancel.
+	buildCtxMu sync.Mutex
+
 	// Helper for producing rich colors and styles to the log.
 	colorizer *colorizer
 
 	// fileModTimeCache remembers the last modified times of files.
 	fileModTimeCache *fileModTimeCache
 
+	// hotSwapStylesheets maps stylesheet source paths registered with
+	// HotSwapStylesheet during the current build to their URLs.
+	hotSwapStylesheets map[string]string
+
+	// hotSwapStylesheetsMu synchronizes access to hotSwapStylesheets.
+	hotSwapStylesheetsMu sync.Mutex
+
+	// incompressible remembers compressed variants that weren't worth
+	// writing (see CompressOutputs).
+	incompressible *incompressibleFiles
+
+	// lastBuildEvent is the websocket event describing the outcome of the
+	// last build loop, sent to connected clients when a build completes.
+	lastBuildEvent *websocketEvent
+
+	// lastBuildEventMu synchronizes access to lastBuildEvent.
+	lastBuildEventMu sync.Mutex
+
+	// outputManifest tracks which jobs produced which files in TargetDir
+	// (see AddOutput).
+	outputManifest *outputManifest
+
+	// persistentStores are values registered with StoreCached to be encoded
+	// the next time the persistent cache is saved.
+	persistentStores map[string]interface{}
+
+	// persistentValues are encoded values loaded from the persistent cache on
+	// startup.
+	persistentValues map[string]json.RawMessage
+
+	// persistentValuesMu synchronizes access to persistentStores and
+	// persistentValues.
+	persistentValuesMu sync.Mutex
+
+	// scheduledRebuilds are future times at which the build loop should
+	// trigger a forced rebuild. Kept sorted with the earliest time first.
+	scheduledRebuilds []time.Time
+
+	// scheduledRebuildsMu synchronizes concurrent access to
+	// scheduledRebuilds.
+	scheduledRebuildsMu sync.Mutex
+
+	// writtenPaths is the set of paths in TargetDir written or removed during
+	// the current build (see MarkWritten).
+	writtenPaths map[string]struct{}
+
+	// writtenPathsMu synchronizes access to writtenPaths.
+	writtenPathsMu sync.Mutex
+
 	// watchedPaths are the set of paths that we're currently watching. This
 	// information is tracked internally by fsnotify as well, but we track it here
 	// as well to help with debugging (for "too many open files" problems and the
@@ -118,20 +214,29 @@ type Context struct {
 // NewContext initializes and returns a new Context.
 func NewContext(args *Args) *Context {
 	c := &Context{
-		Concurrency: args.Concurrency,
-		FirstRun:    true,
-		Log:         args.Log,
-		LogColor:    args.LogColor,
-		Pool:        args.Pool,
-		Port:        args.Port,
-		SourceDir:   args.SourceDir,
-		Stats:       &Stats{},
-		TargetDir:   args.TargetDir,
-		Watcher:     args.Watcher,
-		Websocket:   args.Websocket,
+		BuildReportPath: args.BuildReportPath,
+		CacheKey:        args.CacheKey,
+		CachePath:       args.CachePath,
+		CompressMinSize: args.CompressMinSize,
+		CompressOutputs: args.CompressOutputs,
+		Concurrency:     args.Concurrency,
+		FirstRun:        true,
+		Log:             args.Log,
+		LogColor:        args.LogColor,
+		Pool:            args.Pool,
+		Port:            args.Port,
+		SourceDir:       args.SourceDir,
+		Stats:           &Stats{},
+		TargetDir:       args.TargetDir,
+		TracePath:       args.TracePath,
+		Watcher:         args.Watcher,
+		Websocket:       args.Websocket,
 
 		colorizer:        &colorizer{LogColor: args.LogColor},
-		fileModTimeCache: newFileModTimeCache(args.Log),
+		fileModTimeCache: newFileModTimeCache(args.Log, args.ChangeDetection),
+		incompressible:   newIncompressibleFiles(),
+		outputManifest:   newOutputManifest(),
+		persistentStores: make(map[string]interface{}),
 		watchedPaths:     make(map[string]struct{}),
 	}
 
@@ -143,9 +248,46 @@ func NewContext(args *Args) *Context {
 	return c
 }
 
-// AddJob is a shortcut for adding a new job to the Jobs channel.
-func (c *Context) AddJob(name string, f func() (bool, error)) {
-	c.Jobs <- NewJob(name, f)
+// AddJob is a shortcut for enqueueing a new job in the pool. Returns the job
+// so that it can be used as a dependency of other jobs (see AddJobAfter).
+func (c *Context) AddJob(name string, f func() (bool, error)) *Job {
+	return c.Enqueue(NewJob(name, f))
+}
+
+// AddJobCtx is like AddJob, but the job's function receives a context that's
+// cancelled when the job should stop working (see Job.FCtx).
+func (c *Context) AddJobCtx(name string, f func(ctx context.Context) (bool, error)) *Job {
+	return c.Enqueue(NewJobCtx(name, f))
+}
+
+// AddJobCtxAfter is like AddJobAfter, but the job's function receives a
+// context (see AddJobCtx).
+func (c *Context) AddJobCtxAfter(name string, deps []*Job,
+	f func(ctx context.Context) (bool, error),
+) *Job {
+	job := NewJobCtx(name, f)
+	job.Deps = deps
+	return c.Enqueue(job)
+}
+
+// AddJobAfter is like AddJob, but the job won't run until all of deps have
+// finished successfully. If any of them fail, the job fails without running.
+func (c *Context) AddJobAfter(name string, deps []*Job, f func() (bool, error)) *Job {
+	job := NewJob(name, f)
+	job.Deps = deps
+	return c.Enqueue(job)
+}
+
+// Enqueue enqueues a job in the pool. It's useful over AddJob for jobs that
+// declare dependencies by name (see Job.DepNames). It's safe to call from
+// within a running job, which allows jobs to enqueue more work. Returns the
+// job.
+//
+// The job's name is also recorded so that its outputs (see AddOutput) are kept
+// even if it doesn't record them again.
+func (c *Context) Enqueue(job *Job) *Job {
+	c.outputManifest.markLive(job.Name)
+	return c.Pool.Enqueue(job)
 }
 
 // AllowError is a helper that's useful for when an error coming back from a
@@ -157,9 +299,11 @@ func (c *Context) AllowError(executed bool, err error) bool {
 	return executed
 }
 
-// Changed returns whether the target path's modified time has changed since
-// the last time it was checked. It also saves the last modified time for
-// future checks.
+// Changed returns whether the target path has changed since the last time it
+// was checked. How that's determined depends on the configured change
+// detection strategy (see ChangeDetection), but by default it's whether the
+// path's modified time has changed. It also saves the file's state for future
+// checks.
 //
 // This function is very hot in that it gets checked many times, and probably
 // many times for every single job in a build loop. It needs to be optimized
@@ -181,6 +325,9 @@ func (c *Context) Changed(path string) bool {
 	// Short circuit quickly if the context is in "quick rebuild mode".
 	if c.QuickPaths != nil {
 		_, ok := c.QuickPaths[path]
+		if ok {
+			c.Stats.addChangedPath(path)
+		}
 		return ok
 	}
 
@@ -189,6 +336,7 @@ func (c *Context) Changed(path string) bool {
 		if !os.IsNotExist(err) {
 			c.Log.Errorf("Path passed to Changed doesn't exist: %s", path)
 		}
+		c.Stats.addChangedPath(path)
 		return true
 	}
 
@@ -197,9 +345,14 @@ func (c *Context) Changed(path string) bool {
 	// If we got ok back, then we know the file was in the cache and also
 	// therefore would've been already watched. Return as early as possible.
 	if ok {
+		if changed {
+			c.Stats.addChangedPath(path)
+		}
 		return changed
 	}
 
+	c.Stats.addChangedPath(path)
+
 	if c.Watcher != nil {
 		err := c.addWatched(fileInfo, path)
 		if err != nil {
@@ -231,12 +384,80 @@ func (c *Context) ChangedAny(paths ...string) bool {
 	return changed
 }
 
+// HotSwapStylesheet records that the stylesheet served at url changed because
+// its source at path did. Call it from the build function or a job after
+// checking the source with Changed.
+//
+// If the only changes that triggered a build in quick rebuild mode (see
+// QuickPaths) are to stylesheets registered this way, and no jobs did work,
+// clients connected over the websocket swap in the new stylesheets in place
+// instead of reloading the page, which preserves scroll position and other
+// page state.
+func (c *Context) HotSwapStylesheet(path, url string) {
+	c.hotSwapStylesheetsMu.Lock()
+	defer c.hotSwapStylesheetsMu.Unlock()
+
+	if c.hotSwapStylesheets == nil {
+		c.hotSwapStylesheets = make(map[string]string)
+	}
+	c.hotSwapStylesheets[filepath.Clean(path)] = url
+}
+
+// MarkWritten records that files at the given paths in TargetDir were written
+// during the current build. Clients connected over the websocket use it to
+// reload only if the page they're viewing, or a resource that it loads,
+// changed, so any function that writes to TargetDir should call it. Files
+// removed by pruning stale outputs (see AddOutput) are recorded automatically.
+func (c *Context) MarkWritten(paths ...string) {
+	c.writtenPathsMu.Lock()
+	defer c.writtenPathsMu.Unlock()
+
+	if c.writtenPaths == nil {
+		c.writtenPaths = make(map[string]struct{})
+	}
+	for _, p := range paths {
+		c.writtenPaths[filepath.Clean(p)] = struct{}{}
+	}
+}
+
+// RebuildAt schedules a forced rebuild of the site at the given time. It's
+// useful for content that should appear on its own at some point in the
+// future, like an article with a publish date that hasn't arrived yet.
+//
+// Scheduled times persist across build loops until they elapse, so a job
+// that's skipped because its sources haven't changed doesn't need to register
+// its time again. Times that have already passed are ignored, as are all
+// scheduled times when not running in a build loop.
+func (c *Context) RebuildAt(t time.Time) {
+	if !t.After(time.Now()) {
+		return
+	}
+
+	c.scheduledRebuildsMu.Lock()
+	defer c.scheduledRebuildsMu.Unlock()
+
+	i, found := slices.BinarySearchFunc(c.scheduledRebuilds, t, time.Time.Compare)
+	if found {
+		return
+	}
+
+	c.scheduledRebuilds = slices.Insert(c.scheduledRebuilds, i, t)
+}
+
 // ResetBuild signals to the Context to do the bookkeeping it needs to do for
 // the next build round.
 func (c *Context) ResetBuild() {
 	c.Log.Debugf("Context ResetBuild()")
 	c.Stats.Reset()
 	c.fileModTimeCache.promote()
+
+	c.hotSwapStylesheetsMu.Lock()
+	c.hotSwapStylesheets = nil
+	c.hotSwapStylesheetsMu.Unlock()
+
+	c.writtenPathsMu.Lock()
+	c.writtenPaths = nil
+	c.writtenPathsMu.Unlock()
 }
 
 // StartRound starts a new round for the context, also starting it on its
@@ -248,10 +469,11 @@ func (c *Context) StartRound() {
 	roundNum := c.Stats.NumRounds
 
 	c.Stats.NumRounds++
+	c.Stats.roundSpans = append(c.Stats.roundSpans, timeSpan{start: time.Now()})
 
 	// Then start the pool again, which also has the side effect of
 	// reinitializing anything that needs to be reinitialized.
-	c.Pool.StartRound(roundNum)
+	c.Pool.StartRoundContext(c.buildContext(), roundNum)
 
 	// This channel is reinitialized, so make sure to pull in the new one.
 	c.Jobs = c.Pool.Jobs
@@ -265,9 +487,10 @@ func (c *Context) StartRound() {
 // Returns nil if the round of jobs executed successfully, and a set of errors
 // that occurred otherwise.
 func (c *Context) Wait() []error {
-	c.Log.Debugf("Context Wait(); jobs queued: %v", len(c.Pool.Jobs))
+	c.Log.Debugf("Context Wait(); jobs queued: %v", c.Pool.numJobs())
 
 	c.Stats.LoopDuration += time.Since(c.Stats.lastLoopStart)
+	c.Stats.loopSpans = append(c.Stats.loopSpans, timeSpan{start: c.Stats.lastLoopStart, end: time.Now()})
 
 	defer func() {
 		// Reset the last loop start.
@@ -277,11 +500,16 @@ func (c *Context) Wait() []error {
 	// Wait for work to finish.
 	c.Pool.Wait()
 
+	if len(c.Stats.roundSpans) > 0 {
+		c.Stats.roundSpans[len(c.Stats.roundSpans)-1].end = time.Now()
+	}
+
 	// Note use of append even though we always expect the current set to be
 	// empty so that the slice is duplicated and not affected by its source
 	// being reset by `StartRound` below.
 	c.Stats.JobsErrored = append(c.Stats.JobsErrored, c.Pool.JobsErrored...)
 
+	c.Stats.JobsAll = append(c.Stats.JobsAll, c.Pool.JobsAll...)
 	c.Stats.JobsExecuted = append(c.Stats.JobsExecuted, c.Pool.JobsExecuted...)
 	c.Stats.NumJobs += len(c.Pool.JobsAll)
 
@@ -301,6 +529,69 @@ func (c *Context) Wait() []error {
 	return errors
 }
 
+// Returns the context of the current build loop, or a background context if
+// no build loop has started.
+func (c *Context) buildContext() context.Context {
+	c.buildCtxMu.Lock()
+	defer c.buildCtxMu.Unlock()
+
+	if c.buildCtx == nil {
+		return context.Background()
+	}
+	return c.buildCtx
+}
+
+// Cancels the current build loop, failing all jobs that haven't finished with
+// the given cause. Does nothing if the build has already been cancelled.
+func (c *Context) cancelBuild(cause error) {
+	c.buildCtxMu.Lock()
+	defer c.buildCtxMu.Unlock()
+
+	if c.buildCancel != nil {
+		c.buildCancel(cause)
+	}
+}
+
+// Starts a new context for a build loop, releasing the previous one. Returns
+// the new context.
+func (c *Context) startBuildContext() context.Context {
+	c.buildCtxMu.Lock()
+	defer c.buildCtxMu.Unlock()
+
+	if c.buildCancel != nil {
+		c.buildCancel(nil)
+	}
+
+	c.buildCtx, c.buildCancel = context.WithCancelCause(context.Background())
+	return c.buildCtx
+}
+
+// Returns the earliest scheduled rebuild time and true, or false if no
+// rebuilds are scheduled.
+func (c *Context) nextScheduledRebuild() (time.Time, bool) {
+	c.scheduledRebuildsMu.Lock()
+	defer c.scheduledRebuildsMu.Unlock()
+
+	if len(c.scheduledRebuilds) < 1 {
+		return time.Time{}, false
+	}
+
+	return c.scheduledRebuilds[0], true
+}
+
+// Removes all scheduled rebuild times that are at or before now.
+func (c *Context) removeElapsedScheduledRebuilds(now time.Time) {
+	c.scheduledRebuildsMu.Lock()
+	defer c.scheduledRebuildsMu.Unlock()
+
+	i := 0
+	for i < len(c.scheduledRebuilds) && !c.scheduledRebuilds[i].After(now) {
+		i++
+	}
+
+	c.scheduledRebuilds = c.scheduledRebuilds[i:]
+}
+
 func (c *Context) addWatched(fileInfo os.FileInfo, absolutePath string) error {
 	// Watch the parent directory unless the file is a directory itself. This
 	// will hopefully mean fewer individual entries in the notifier.
@@ -331,6 +622,9 @@ func (c *Context) addWatched(fileInfo os.FileInfo, absolutePath string) error {
 
 // Stats tracks various statistics about the build process.
 type Stats struct {
+	// JobsAll is a slice of all jobs that were enqueued across all runs.
+	JobsAll []*Job
+
 	// JobsErrored is a slice of jobs that errored on the last run.
 	//
 	// Differs from JobsExecuted somewhat in that only one run of errors are
@@ -357,13 +651,46 @@ type Stats struct {
 	// Start is the start time of the build loop.
 	Start time.Time
 
+	// changedPaths is the set of paths that Changed reported as changed
+	// during the build loop, excluding those reported because the context
+	// was forced.
+	changedPaths map[string]struct{}
+
+	// changedPathsMu synchronizes access to changedPaths, which is added to
+	// from jobs running concurrently.
+	changedPathsMu sync.Mutex
+
 	// lastLoopStart is when the last user build loop started (i.e. this is set
 	// to the current timestamp whenever a call to context.Wait finishes).
 	lastLoopStart time.Time
+
+	// loopSpans are the periods spent in the user's build loop enqueuing
+	// jobs (the components of LoopDuration).
+	loopSpans []timeSpan
+
+	// roundSpans are the periods during which each round was running. The
+	// last round may not have an end if it wasn't waited on with
+	// context.Wait.
+	roundSpans []timeSpan
+}
+
+// ChangedPaths returns the paths that Changed reported as changed during the
+// build loop, sorted. Paths reported only because the context was forced
+// aren't included.
+func (s *Stats) ChangedPaths() []string {
+	s.changedPathsMu.Lock()
+	defer s.changedPathsMu.Unlock()
+
+	return sortedMapKeys(s.changedPaths)
 }
 
 // Reset resets statistics.
 func (s *Stats) Reset() {
+	s.changedPathsMu.Lock()
+	s.changedPaths = nil
+	s.changedPathsMu.Unlock()
+
+	s.JobsAll = nil
 	s.JobsErrored = nil
 	s.JobsExecuted = nil
 	s.LoopDuration = time.Duration(0)
@@ -371,6 +698,8 @@ func (s *Stats) Reset() {
 	s.NumRounds = 0
 	s.Start = time.Now()
 	s.lastLoopStart = time.Now()
+	s.loopSpans = nil
+	s.roundSpans = nil
 }
 
 //////////////////////////////////////////////////////////////////////////////
@@ -383,60 +712,184 @@ func (s *Stats) Reset() {
 //
 //////////////////////////////////////////////////////////////////////////////
 
-// FileModTimeCache tracks the last modified time of files seen so a
-// determination can be made as to whether they need to be recompiled.
+// FileModTimeCache tracks the last modified time (and depending on the change
+// detection strategy, size and content hash) of files seen so a determination
+// can be made as to whether they need to be recompiled.
 type fileModTimeCache struct {
-	log                 LoggerInterface
-	mu                  sync.Mutex
-	pathToModTimeMap    map[string]time.Time
-	pathToModTimeMapNew map[string]time.Time
+	log      LoggerInterface
+	mu       sync.Mutex
+	strategy ChangeDetection
+
+	pathToFileStateMap    map[string]fileState
+	pathToFileStateMapNew map[string]fileState
+}
+
+// The state of a file as last seen by fileModTimeCache.
+type fileState struct {
+	// Hash is a hex-encoded SHA-256 hash of the file's contents. Only
+	// populated for change detection strategies that use hashing.
+	Hash string `json:"hash,omitempty"`
+
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
 }
 
 // newFileModTimeCache returns a new fileModTimeCache.
-func newFileModTimeCache(log LoggerInterface) *fileModTimeCache {
+func newFileModTimeCache(log LoggerInterface, strategy ChangeDetection) *fileModTimeCache {
+	if strategy == "" {
+		strategy = ChangeDetectionModTime
+	}
+
 	return &fileModTimeCache{
-		log:                 log,
-		pathToModTimeMap:    make(map[string]time.Time),
-		pathToModTimeMapNew: make(map[string]time.Time),
+		log:                   log,
+		strategy:              strategy,
+		pathToFileStateMap:    make(map[string]fileState),
+		pathToFileStateMapNew: make(map[string]fileState),
 	}
 }
 
-// changed returns whether the target path's modified time has changed since
-// the last time it was checked. It also saves the last modified time for
-// future checks. The second return value is whether or not the record was
-// already in the cache.
+// changed returns whether the target path has changed since the last time it
+// was checked according to the cache's change detection strategy. It also
+// saves the file's state for future checks. The second return value is
+// whether or not the record was already in the cache.
 func (c *fileModTimeCache) isFileUpdated(fileInfo os.FileInfo, absolutePath string) (bool, bool) {
-	modTime := fileInfo.ModTime()
+	state := fileState{ModTime: fileInfo.ModTime(), Size: fileInfo.Size()}
+
+	lastState, ok := c.pathToFileStateMap[absolutePath]
+
+	// Directories can't be hashed, so always fall back to modification times
+	// for them.
+	if c.strategy == ChangeDetectionModTime || fileInfo.IsDir() {
+		if ok {
+			changed := lastState.ModTime.Before(state.ModTime)
+			if !changed {
+				return false, ok
+			}
+		}
 
-	lastModTime, ok := c.pathToModTimeMap[absolutePath]
+		c.storeNew(absolutePath, state)
+		return true, ok
+	}
 
-	if ok {
-		changed := lastModTime.Before(modTime)
-		if !changed {
-			return false, ok
-		}
+	// When modification time and size are unchanged, trust that the contents
+	// are too rather than paying for a hash.
+	if ok && c.strategy == ChangeDetectionModTimeThenHash &&
+		lastState.ModTime.Equal(state.ModTime) && lastState.Size == state.Size {
+		return false, ok
 	}
 
-	// Store to the new map for eventual promotion.
+	hash, err := c.hashFile(absolutePath, state)
+	if err != nil {
+		c.log.Errorf("Error hashing file for change detection: %v", err)
+		return true, ok
+	}
+	state.Hash = hash
+
+	// Store even if unchanged so that the file's new modification time is
+	// recorded and it won't need to be hashed again.
+	c.storeNew(absolutePath, state)
+
+	if ok && lastState.Hash == state.Hash {
+		return false, ok
+	}
+
+	return true, ok
+}
+
+// Hashes the contents of the file at the given path. Paths are usually
+// checked many times per round, so if the file was already hashed this round
+// and its modification time and size haven't changed since, that hash is
+// reused instead of reading the file again.
+func (c *fileModTimeCache) hashFile(absolutePath string, state fileState) (string, error) {
 	c.mu.Lock()
-	c.pathToModTimeMapNew[absolutePath] = modTime
+	newState, ok := c.pathToFileStateMapNew[absolutePath]
 	c.mu.Unlock()
 
-	return true, ok
+	if ok && newState.Hash != "" &&
+		newState.ModTime.Equal(state.ModTime) && newState.Size == state.Size {
+		return newState.Hash, nil
+	}
+
+	file, err := os.Open(absolutePath)
+	if err != nil {
+		return "", xerrors.Errorf("error opening file '%s': %w", absolutePath, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", xerrors.Errorf("error hashing file '%s': %w", absolutePath, err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Stores a file's state to the new map for eventual promotion.
+func (c *fileModTimeCache) storeNew(absolutePath string, state fileState) {
+	c.mu.Lock()
+	c.pathToFileStateMapNew[absolutePath] = state
+	c.mu.Unlock()
 }
 
-// promote takes all the new modification times collected during this round
-// (i.e. a build phase) and promotes them into the main map so that they're
-// available for the next one.
+// promote takes all the new file states collected during this round (i.e. a
+// build phase) and promotes them into the main map so that they're available
+// for the next one.
 func (c *fileModTimeCache) promote() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	// Promote all new values to the current map.
-	for path, modTime := range c.pathToModTimeMapNew {
-		c.pathToModTimeMap[path] = modTime
+	for path, state := range c.pathToFileStateMapNew {
+		c.pathToFileStateMap[path] = state
 	}
 
 	// Clear the new map for the next round.
-	c.pathToModTimeMapNew = make(map[string]time.Time)
+	c.pathToFileStateMapNew = make(map[string]fileState)
+}
+
+// discard throws away all new file states collected during this round so
+// that any changes they represent are detected again in the next one.
+func (c *fileModTimeCache) discard() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pathToFileStateMapNew = make(map[string]fileState)
+}
+
+// load seeds the cache with file states from a persistent cache.
+func (c *fileModTimeCache) load(states map[string]fileState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for path, state := range states {
+		c.pathToFileStateMap[path] = state
+	}
+}
+
+// snapshot returns a copy of all known file states, including new ones
+// collected during the current round that haven't been promoted yet.
+func (c *fileModTimeCache) snapshot() map[string]fileState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	states := make(map[string]fileState, len(c.pathToFileStateMap)+len(c.pathToFileStateMapNew))
+	for path, state := range c.pathToFileStateMap {
+		states[path] = state
+	}
+	for path, state := range c.pathToFileStateMapNew {
+		states[path] = state
+	}
+
+	return states
+}
+
+// Records that Changed reported path as changed.
+func (s *Stats) addChangedPath(path string) {
+	s.changedPathsMu.Lock()
+	defer s.changedPathsMu.Unlock()
+
+	if s.changedPaths == nil {
+		s.changedPaths = make(map[string]struct{})
+	}
+	s.changedPaths[path] = struct{}{}
 }
@@ -1 +1,122 @@
 package modulir
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestContextRebuildAt(t *testing.T) {
+	c := NewContext(&Args{Log: &Logger{Level: LevelInfo}})
+
+	_, ok := c.nextScheduledRebuild()
+	assert.False(t, ok)
+
+	now := time.Now()
+	t1 := now.Add(1 * time.Hour)
+	t2 := now.Add(2 * time.Hour)
+
+	// Added out of order and with a duplicate.
+	c.RebuildAt(t2)
+	c.RebuildAt(t1)
+	c.RebuildAt(t2)
+
+	// Times in the past are ignored.
+	c.RebuildAt(now.Add(-1 * time.Hour))
+
+	assert.Equal(t, []time.Time{t1, t2}, c.scheduledRebuilds)
+
+	next, ok := c.nextScheduledRebuild()
+	assert.True(t, ok)
+	assert.Equal(t, t1, next)
+
+	c.removeElapsedScheduledRebuilds(t1)
+	assert.Equal(t, []time.Time{t2}, c.scheduledRebuilds)
+
+	c.removeElapsedScheduledRebuilds(t2.Add(1 * time.Second))
+	_, ok = c.nextScheduledRebuild()
+	assert.False(t, ok)
+}
+
+func TestFileModTimeCacheStrategies(t *testing.T) {
+	// Runs a check through the cache and promotes so that the result is
+	// visible to the next check, as would happen between rounds.
+	check := func(t *testing.T, c *fileModTimeCache, path string) bool {
+		t.Helper()
+
+		fileInfo, err := os.Stat(path)
+		assert.NoError(t, err)
+
+		changed, _ := c.isFileUpdated(fileInfo, path)
+		c.promote()
+		return changed
+	}
+
+	setup := func(t *testing.T) (string, time.Time) {
+		t.Helper()
+
+		path := filepath.Join(t.TempDir(), "file")
+		assert.NoError(t, os.WriteFile(path, []byte("hello"), 0o600))
+
+		modTime := time.Now().Add(-1 * time.Hour).Truncate(time.Second)
+		assert.NoError(t, os.Chtimes(path, modTime, modTime))
+
+		return path, modTime
+	}
+
+	t.Run("ModTime", func(t *testing.T) {
+		path, modTime := setup(t)
+		c := newFileModTimeCache(&Logger{Level: LevelWarn}, ChangeDetectionModTime)
+
+		assert.True(t, check(t, c, path))
+		assert.False(t, check(t, c, path))
+
+		// Newer modification time with identical contents is a change.
+		assert.NoError(t, os.Chtimes(path, modTime.Add(time.Minute), modTime.Add(time.Minute)))
+		assert.True(t, check(t, c, path))
+		assert.False(t, check(t, c, path))
+	})
+
+	t.Run("ModTimeThenHash", func(t *testing.T) {
+		path, modTime := setup(t)
+		c := newFileModTimeCache(&Logger{Level: LevelWarn}, ChangeDetectionModTimeThenHash)
+
+		assert.True(t, check(t, c, path))
+		assert.False(t, check(t, c, path))
+
+		// Newer modification time with identical contents is not a change.
+		assert.NoError(t, os.Chtimes(path, modTime.Add(time.Minute), modTime.Add(time.Minute)))
+		assert.False(t, check(t, c, path))
+
+		// New contents are.
+		assert.NoError(t, os.WriteFile(path, []byte("world!"), 0o600))
+		assert.True(t, check(t, c, path))
+		assert.False(t, check(t, c, path))
+
+		// Older modification time with new contents of the same size is
+		// also a change, unlike with the mtime strategy.
+		assert.NoError(t, os.WriteFile(path, []byte("WORLD!"), 0o600))
+		assert.NoError(t, os.Chtimes(path, modTime, modTime))
+		assert.True(t, check(t, c, path))
+	})
+
+	t.Run("Hash", func(t *testing.T) {
+		path, modTime := setup(t)
+		c := newFileModTimeCache(&Logger{Level: LevelWarn}, ChangeDetectionHash)
+
+		assert.True(t, check(t, c, path))
+		assert.False(t, check(t, c, path))
+
+		assert.NoError(t, os.Chtimes(path, modTime.Add(time.Minute), modTime.Add(time.Minute)))
+		assert.False(t, check(t, c, path))
+
+		// Hashes are used even if modification time and size are
+		// identical.
+		assert.NoError(t, os.WriteFile(path, []byte("HELLO"), 0o600))
+		assert.NoError(t, os.Chtimes(path, modTime.Add(time.Minute), modTime.Add(time.Minute)))
+		assert.True(t, check(t, c, path))
+	})
+}
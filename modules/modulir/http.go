@@ -5,10 +5,13 @@ package modulir
 import (
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"text/template"
 	"time"
@@ -29,26 +32,16 @@ import (
 
 // Starts serving the built site over HTTP on the configured port. A server
 // instance is returned so that it can be shut down gracefully.
+//
+// Paths that don't exist are served the page at NotFoundPage in TargetDir (if
+// there is one) with a 404 status, and precompressed variants of files (see
+// CompressOutputs) are served to clients that accept them, like most static
+// hosts do.
 func startServingTargetDirHTTP(c *Context, buildComplete *sync.Cond) *http.Server {
 	c.Log.Infof("Serving '%s' to: http://localhost:%v/", path.Clean(c.TargetDir), c.Port)
 
 	mux := http.NewServeMux()
-
-	// Wrap the file server to handle "pretty URLs"
-	fileServer := http.FileServer(http.Dir(c.TargetDir))
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		requestPath := r.URL.Path
-		fullPath := filepath.Join(c.TargetDir, requestPath)
-
-		// If file does not exist, try appending .html
-		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-			if _, err := os.Stat(fullPath + ".html"); err == nil {
-				r.URL.Path = requestPath + ".html"
-			}
-		}
-
-		fileServer.ServeHTTP(w, r)
-	})
+	mux.HandleFunc("/", getTargetDirHandler(c))
 
 	if c.Websocket {
 		mux.HandleFunc("/websocket.js", getWebsocketJSHandler(c))
@@ -74,6 +67,10 @@ func startServingTargetDirHTTP(c *Context, buildComplete *sync.Cond) *http.Serve
 	return server
 }
 
+// NotFoundPage is the name of the page in TargetDir that's served for paths
+// that don't exist.
+const NotFoundPage = "404.html"
+
 //////////////////////////////////////////////////////////////////////////////
 //
 //
@@ -84,12 +81,195 @@ func startServingTargetDirHTTP(c *Context, buildComplete *sync.Cond) *http.Serve
 //
 //////////////////////////////////////////////////////////////////////////////
 
+// Returns a handler that serves files in TargetDir, handling "pretty URLs"
+// (i.e. `/about` serves `/about.html`), precompressed variants, and paths that
+// don't exist.
+func getTargetDirHandler(c *Context) func(w http.ResponseWriter, r *http.Request) {
+	fileServer := http.FileServer(http.Dir(c.TargetDir))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestPath := r.URL.Path
+		fullPath := filepath.Join(c.TargetDir, requestPath)
+
+		// If file does not exist, try appending .html
+		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+			if _, err := os.Stat(fullPath + ".html"); err != nil {
+				serveNotFound(c, w, r)
+				return
+			}
+
+			r.URL.Path = requestPath + ".html"
+			fullPath += ".html"
+		} else if strings.HasSuffix(requestPath, "/") {
+			fullPath = filepath.Join(fullPath, "index.html")
+		}
+
+		if serveCompressed(w, r, fullPath) {
+			return
+		}
+
+		fileServer.ServeHTTP(w, r)
+	}
+}
+
+// Parses an Accept-Encoding header into the set of encodings that it accepts,
+// leaving out any with a quality of zero.
+func parseAcceptEncoding(header string) map[string]struct{} {
+	encodings := make(map[string]struct{})
+
+	for _, part := range strings.Split(header, ",") {
+		encoding, params, _ := strings.Cut(part, ";")
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if encoding == "" {
+			continue
+		}
+
+		if quality, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(quality, 64); err == nil && q <= 0 {
+				continue
+			}
+		}
+
+		encodings[encoding] = struct{}{}
+	}
+
+	return encodings
+}
+
+// Serves a precompressed variant of the file at fullPath (see CompressOutputs)
+// if there's one for an encoding the client accepts, returning true if it did.
+// Variants older than the file are ignored so that a stale one isn't served
+// while a build is still compressing them.
+func serveCompressed(w http.ResponseWriter, r *http.Request, fullPath string) bool {
+	if _, ok := compressibleExts[filepath.Ext(fullPath)]; !ok {
+		return false
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	// Caches need to know that the response depends on the header whether or
+	// not a variant ends up being served.
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	accepted := parseAcceptEncoding(r.Header.Get("Accept-Encoding"))
+
+	for _, encoding := range compressedEncodings {
+		if _, ok := accepted[encoding.Encoding]; !ok {
+			continue
+		}
+
+		if serveCompressedVariant(w, r, fullPath, info, encoding) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Serves the variant of the file at fullPath for the given encoding if it
+// exists and is up to date, returning true if it did.
+func serveCompressedVariant(w http.ResponseWriter, r *http.Request, fullPath string,
+	info os.FileInfo, encoding *compressedEncoding,
+) bool {
+	f, err := os.Open(fullPath + encoding.Ext)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	compressedInfo, err := f.Stat()
+	if err != nil || compressedInfo.ModTime().Before(info.ModTime()) {
+		return false
+	}
+
+	// Set explicitly because ServeContent would otherwise sniff the type from
+	// compressed bytes.
+	contentType := mime.TypeByExtension(filepath.Ext(fullPath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Encoding", encoding.Encoding)
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, fullPath, compressedInfo.ModTime(), f)
+
+	return true
+}
+
+// Serves NotFoundPage with a 404 status, or a plain error if it doesn't exist.
+func serveNotFound(c *Context, w http.ResponseWriter, r *http.Request) {
+	data, err := os.ReadFile(filepath.Join(c.TargetDir, NotFoundPage))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.Log.Errorf("Error reading not found page: %v", err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+
+	// A HEAD request is used by clients of the websocket to find out whether
+	// they're looking at the not found page, and has no body.
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if _, err := w.Write(data); err != nil {
+		c.Log.Errorf("Error writing not found page: %v", err)
+	}
+}
+
 // A type representing the extremely basic messages that we'll be serializing
 // and sending back over a websocket.
 type websocketEvent struct {
-	Type string `json:"type"`
+	// Errors are the errors that caused a build to fail. Only set for
+	// websocketEventTypeBuildFailed.
+	Errors []*websocketEventError `json:"errors,omitempty"`
+
+	// Paths are the URL paths of files in the target directory that were
+	// written or removed during a build. Only set for
+	// websocketEventTypeBuildComplete.
+	Paths []string `json:"paths,omitempty"`
+
+	Type websocketEventType `json:"type"`
+
+	// URLs are the URLs of stylesheets that changed. Only set for
+	// websocketEventTypeCSSChanged.
+	URLs []string `json:"urls,omitempty"`
 }
 
+// An error in a websocketEvent.
+type websocketEventError struct {
+	// Job is the name of the job that errored, or empty if the error came
+	// from the build function itself.
+	Job string `json:"job,omitempty"`
+
+	Message string `json:"message"`
+}
+
+// The type of a websocketEvent.
+type websocketEventType string
+
+const (
+	// Sent after a successful build. Clients reload the page if it, or a
+	// resource that it loads, is among the event's paths.
+	websocketEventTypeBuildComplete websocketEventType = "build_complete"
+
+	// Sent after a failed build, and on connecting while the last build is
+	// failed. Clients show the errors in an overlay instead of reloading.
+	websocketEventTypeBuildFailed websocketEventType = "build_failed"
+
+	// Sent after a successful build where only stylesheets changed (see
+	// Context.HotSwapStylesheet). Clients swap in the new stylesheets
+	// instead of reloading.
+	websocketEventTypeCSSChanged websocketEventType = "css_changed"
+)
+
 const (
 	// Maximum message size allowed from peer.
 	websocketMaxMessageSize = 512
@@ -124,6 +304,115 @@ var websocketUpgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
+// Produces a websocket event describing the outcome of the build loop that
+// just finished. Errors includes all errors from the loop, some of which may
+// be errored jobs.
+func newBuildEvent(c *Context, errs []error) *websocketEvent {
+	var eventErrors []*websocketEventError
+
+	for _, job := range c.Stats.JobsErrored {
+		eventErrors = append(eventErrors, &websocketEventError{
+			Job:     job.Name,
+			Message: job.Err.Error(),
+		})
+	}
+
+	// Errors returned by the build function itself, which are the ones that
+	// aren't jobs already included above.
+	for _, err := range errs {
+		var job *Job
+		if errors.As(err, &job) {
+			continue
+		}
+
+		eventErrors = append(eventErrors, &websocketEventError{Message: err.Error()})
+	}
+
+	if len(eventErrors) > 0 {
+		return &websocketEvent{Errors: eventErrors, Type: websocketEventTypeBuildFailed}
+	}
+
+	if urls := hotSwappableStylesheetURLs(c); urls != nil {
+		return &websocketEvent{Type: websocketEventTypeCSSChanged, URLs: urls}
+	}
+
+	return &websocketEvent{Paths: writtenURLPaths(c), Type: websocketEventTypeBuildComplete}
+}
+
+// Returns the URL paths of files written to TargetDir during the current
+// build (see MarkWritten), sorted.
+func writtenURLPaths(c *Context) []string {
+	c.writtenPathsMu.Lock()
+	defer c.writtenPathsMu.Unlock()
+
+	if len(c.writtenPaths) < 1 {
+		return nil
+	}
+
+	targetDir, err := filepath.Abs(c.TargetDir)
+	if err != nil {
+		c.Log.Errorf("Error getting absolute path for '%s': %v", c.TargetDir, err)
+		return nil
+	}
+
+	urlPathsSet := make(map[string]struct{}, len(c.writtenPaths))
+	for p := range c.writtenPaths {
+		absPath, err := filepath.Abs(p)
+		if err != nil {
+			continue
+		}
+
+		relPath, err := filepath.Rel(targetDir, absPath)
+		if err != nil || strings.HasPrefix(relPath, "..") {
+			continue
+		}
+		urlPathsSet["/"+filepath.ToSlash(relPath)] = struct{}{}
+	}
+
+	return sortedMapKeys(urlPathsSet)
+}
+
+// Returns the URLs of stylesheets that clients can swap in place of reloading
+// the page after a quick build, or nil if a reload is needed. That's the case
+// unless every path that triggered the build is a stylesheet registered with
+// HotSwapStylesheet and no jobs did work. Expects to be called before
+// QuickPaths is reset.
+func hotSwappableStylesheetURLs(c *Context) []string {
+	if len(c.QuickPaths) < 1 || len(c.Stats.JobsExecuted) > 0 {
+		return nil
+	}
+
+	c.hotSwapStylesheetsMu.Lock()
+	defer c.hotSwapStylesheetsMu.Unlock()
+
+	urlsSet := make(map[string]struct{}, len(c.QuickPaths))
+	for path := range c.QuickPaths {
+		url, ok := c.hotSwapStylesheets[path]
+		if !ok {
+			return nil
+		}
+		urlsSet[url] = struct{}{}
+	}
+
+	return sortedMapKeys(urlsSet)
+}
+
+// Returns the event describing the outcome of the last build loop, or nil if
+// there hasn't been one.
+func (c *Context) getLastBuildEvent() *websocketEvent {
+	c.lastBuildEventMu.Lock()
+	defer c.lastBuildEventMu.Unlock()
+
+	return c.lastBuildEvent
+}
+
+func (c *Context) setLastBuildEvent(event *websocketEvent) {
+	c.lastBuildEventMu.Lock()
+	defer c.lastBuildEventMu.Unlock()
+
+	c.lastBuildEvent = event
+}
+
 func getWebsocketHandler(c *Context, buildComplete *sync.Cond) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		conn, err := websocketUpgrader.Upgrade(w, r, nil)
@@ -144,7 +433,8 @@ func getWebsocketJSHandler(c *Context) func(w http.ResponseWriter, r *http.Reque
 	return func(w http.ResponseWriter, _ *http.Request) {
 		w.Header().Set("Content-Type", "text/javascript")
 		err := websocketJSTemplate.Execute(w, map[string]interface{}{
-			"Port": c.Port,
+			"NotFoundPath": "/" + NotFoundPage,
+			"Port":         c.Port,
 		})
 		if err != nil {
 			c.Log.Errorf("Error executing template/writing websocket.js: %v", err)
@@ -215,37 +505,60 @@ func websocketWritePump(c *Context, conn *websocket.Conn,
 	var writeErr error
 	sendComplete := make(chan struct{}, 1)
 
+	writeEvent := func(event *websocketEvent) error {
+		if err := conn.SetWriteDeadline(time.Now().Add(websocketWriteWait)); err != nil {
+			c.Log.Errorf(logPrefix(c, conn)+"Couldn't set WebSocket read deadline: %v",
+				err)
+		}
+		return conn.WriteJSON(event)
+	}
+
+	// A client connecting while the last build is failed (e.g. because the
+	// page was reloaded) should see its errors right away rather than only
+	// after the next build.
+	if event := c.getLastBuildEvent(); event != nil && event.Type == websocketEventTypeBuildFailed {
+		if err := writeEvent(event); err != nil {
+			c.Log.Errorf(logPrefix(c, conn)+"Error writing: %v", err)
+			return
+		}
+	}
+
 	// This is a hack because of course there's no way to select on a
 	// conditional variable. Instead, we have a separate Goroutine wait on the
 	// conditional variable and signal the main select below through a channel.
 	buildCompleteChan := make(chan struct{}, 1)
+
+	// Closed when the write pump ends so that the feeder Goroutine below
+	// knows to stop too.
+	pumpDone := make(chan struct{})
+	defer close(pumpDone)
+
 	go func() {
+	feedLoop:
 		for {
 			buildComplete.L.Lock()
 			buildComplete.Wait()
 			buildComplete.L.Unlock()
 
-			buildCompleteChan <- struct{}{}
+			select {
+			case buildCompleteChan <- struct{}{}:
+			case <-pumpDone:
+				break feedLoop
+			}
 
-			// Break out of the Goroutine when we can to prevent a Goroutine
-			// leak.
-			//
-			// Unfortunately this isn't perfect. If we were sending a
-			// build_complete, the Goroutine will die right away because the
-			// wait below will fall through after the message was fully
-			// received, and the client-side JavaScript will being the page
-			// reload and close the websocket before that occurs. That's good.
+			// Break out of the Goroutine once the write pump has ended to
+			// prevent a Goroutine leak.
 			//
-			// What isn't so good is that for other exit conditions like a
-			// closed connection or a failed ping, the Goroutine will still be
-			// waiting on the conditional variable's Wait above, and not exit
-			// right away. The good news is that the next build event that
-			// triggers will cause it to fall through and end the Goroutine. So
-			// it will eventually be cleaned up, but that clean up may be
-			// delayed.
-			<-sendComplete
-			if done {
-				break
+			// Unfortunately this isn't perfect. The Goroutine spends most of
+			// its time waiting on the conditional variable's Wait above, and
+			// won't notice that the pump has ended (say, because the client
+			// reloaded the page after a build_complete, or a ping failed)
+			// until the next build event causes it to fall through. So it
+			// will eventually be cleaned up, but that clean up may be delayed.
+			select {
+			case <-sendComplete:
+			case <-pumpDone:
+				break feedLoop
 			}
 		}
 
@@ -255,11 +568,11 @@ func websocketWritePump(c *Context, conn *websocket.Conn,
 	for {
 		select {
 		case <-buildCompleteChan:
-			if err := conn.SetWriteDeadline(time.Now().Add(websocketWriteWait)); err != nil {
-				c.Log.Errorf(logPrefix(c, conn)+"Couldn't set WebSocket read deadline: %v",
-					err)
+			event := c.getLastBuildEvent()
+			if event == nil {
+				event = &websocketEvent{Type: websocketEventTypeBuildComplete}
 			}
-			writeErr = conn.WriteJSON(websocketEvent{Type: "build_complete"})
+			writeErr = writeEvent(event)
 
 			// Send shouldn't strictly need to be non-blocking, but we do one
 			// anyway just to hedge against future or unexpected problems so as
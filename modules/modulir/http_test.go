@@ -1 +1,318 @@
 package modulir
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	assert "github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestNewBuildEvent(t *testing.T) {
+	c := NewContext(&Args{
+		Log:  &Logger{Level: LevelWarn},
+		Pool: NewPool(&Logger{Level: LevelWarn}, 1),
+	})
+
+	c.ResetBuild()
+	c.StartRound()
+	c.AddJob("job 0", func() (bool, error) { return true, nil })
+	errs := c.Wait()
+	c.Pool.Wait()
+
+	assert.Equal(t, &websocketEvent{Type: websocketEventTypeBuildComplete},
+		newBuildEvent(c, errs))
+
+	c.ResetBuild()
+	c.StartRound()
+	c.AddJob("job 0", func() (bool, error) { return true, xerrors.Errorf("bad frontmatter") })
+	errs = c.Wait()
+	c.Pool.Wait()
+
+	// An error from the build function itself.
+	errs = append(errs, xerrors.Errorf("error reading directory"))
+
+	assert.Equal(t, &websocketEvent{
+		Errors: []*websocketEventError{
+			{Job: "job 0", Message: "bad frontmatter"},
+			{Message: "error reading directory"},
+		},
+		Type: websocketEventTypeBuildFailed,
+	}, newBuildEvent(c, errs))
+}
+
+func TestNewBuildEventCSSChanged(t *testing.T) {
+	c := NewContext(&Args{
+		Log:  &Logger{Level: LevelWarn},
+		Pool: NewPool(&Logger{Level: LevelWarn}, 1),
+	})
+
+	build := func(quickPaths []string, f func() (bool, error)) *websocketEvent {
+		c.ResetBuild()
+		c.StartRound()
+
+		c.QuickPaths = make(map[string]struct{})
+		for _, path := range quickPaths {
+			c.QuickPaths[path] = struct{}{}
+		}
+
+		c.AddJob("stylesheets", func() (bool, error) {
+			for _, source := range []string{"web/stylesheets/a.css", "web/stylesheets/b.css"} {
+				if c.Changed(source) {
+					c.HotSwapStylesheet(source, "/"+filepath.Base(source))
+				}
+			}
+			return false, nil
+		})
+		c.AddJob("job", f)
+
+		errs := c.Wait()
+		c.Pool.Wait()
+
+		event := newBuildEvent(c, errs)
+		c.QuickPaths = nil
+		return event
+	}
+
+	noWork := func() (bool, error) { return false, nil }
+
+	assert.Equal(t, &websocketEvent{
+		Type: websocketEventTypeCSSChanged,
+		URLs: []string{"/a.css", "/b.css"},
+	}, build([]string{"web/stylesheets/a.css", "web/stylesheets/b.css"}, noWork))
+
+	// Something other than a stylesheet changed.
+	assert.Equal(t, websocketEventTypeBuildComplete,
+		build([]string{"web/stylesheets/a.css", "web/html/index.tmpl.html"}, noWork).Type)
+
+	// A job did work.
+	assert.Equal(t, websocketEventTypeBuildComplete,
+		build([]string{"web/stylesheets/a.css"}, func() (bool, error) { return true, nil }).Type)
+
+	// Not a quick build.
+	c.ResetBuild()
+	c.HotSwapStylesheet("web/stylesheets/a.css", "/a.css")
+	assert.Equal(t, websocketEventTypeBuildComplete, newBuildEvent(c, nil).Type)
+}
+
+func TestNewBuildEventWrittenPaths(t *testing.T) {
+	dir := t.TempDir()
+
+	c := NewContext(&Args{
+		Log:       &Logger{Level: LevelWarn},
+		Pool:      NewPool(&Logger{Level: LevelWarn}, 1),
+		TargetDir: filepath.Join(dir, "public"),
+	})
+
+	c.ResetBuild()
+	c.MarkWritten(
+		filepath.Join(dir, "public", "about.html"),
+		filepath.Join(dir, "public", "content", "images", "about", "a.png"),
+		filepath.Join(dir, "outside.html"),
+	)
+	c.MarkWritten(filepath.Join(dir, "public", "about.html"))
+
+	assert.Equal(t, &websocketEvent{
+		Paths: []string{"/about.html", "/content/images/about/a.png"},
+		Type:  websocketEventTypeBuildComplete,
+	}, newBuildEvent(c, nil))
+
+	// Written paths are reset between builds.
+	c.ResetBuild()
+	assert.Equal(t, &websocketEvent{Type: websocketEventTypeBuildComplete},
+		newBuildEvent(c, nil))
+}
+
+func TestParseAcceptEncoding(t *testing.T) {
+	assert.Equal(t, map[string]struct{}{}, parseAcceptEncoding(""))
+	assert.Equal(t, map[string]struct{}{"br": {}, "deflate": {}, "gzip": {}},
+		parseAcceptEncoding("gzip, deflate, br"))
+	assert.Equal(t, map[string]struct{}{"gzip": {}},
+		parseAcceptEncoding("GZIP;q=0.5, br;q=0"))
+}
+
+func TestTargetDirHandler(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "about.html"), []byte("about"), 0o600))
+
+	c := NewContext(&Args{Log: &Logger{Level: LevelWarn}, TargetDir: dir})
+
+	server := httptest.NewServer(http.HandlerFunc(getTargetDirHandler(c)))
+	defer server.Close()
+
+	get := func(path string) (int, string, string) {
+		resp, err := http.Get(server.URL + path)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+
+		return resp.StatusCode, resp.Header.Get("Content-Type"), string(body)
+	}
+
+	status, _, body := get("/about.html")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "about", body)
+
+	// Pretty URL.
+	status, _, body = get("/about")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "about", body)
+
+	// No not found page has been built.
+	status, _, body = get("/missing")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "404 page not found\n", body)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, NotFoundPage), []byte("not found"), 0o600))
+
+	status, contentType, body := get("/missing")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "text/html; charset=utf-8", contentType)
+	assert.Equal(t, "not found", body)
+}
+
+func TestTargetDirHandlerCompressed(t *testing.T) {
+	dir := t.TempDir()
+
+	css := []byte(strings.Repeat("body { color: red; }\n", 100))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "main.css"), css, 0o600))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "about"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "about", "index.html"), css, 0o600))
+
+	c := NewContext(&Args{
+		CompressMinSize: 1024,
+		Log:             &Logger{Level: LevelWarn},
+		Pool:            NewPool(&Logger{Level: LevelWarn}, 1),
+		TargetDir:       dir,
+	})
+
+	c.ResetBuild()
+	c.StartRound()
+	assert.Empty(t, c.compressOutputs())
+	c.Pool.Wait()
+
+	server := httptest.NewServer(http.HandlerFunc(getTargetDirHandler(c)))
+	defer server.Close()
+
+	// The transport would otherwise request and transparently decode gzip.
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	get := func(path, acceptEncoding string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		assert.NoError(t, err)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp, body
+	}
+
+	gzipData, err := os.ReadFile(filepath.Join(dir, "main.css.gz"))
+	assert.NoError(t, err)
+	brotliData, err := os.ReadFile(filepath.Join(dir, "main.css.br"))
+	assert.NoError(t, err)
+
+	resp, body := get("/main.css", "")
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, css, body)
+
+	resp, body = get("/main.css", "gzip, deflate")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "text/css; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, gzipData, body)
+
+	// Brotli is preferred when both are accepted.
+	resp, body = get("/main.css", "gzip, deflate, br")
+	assert.Equal(t, "br", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, brotliData, body)
+
+	resp, _ = get("/main.css", "gzip;q=0, br;q=0")
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+
+	// Directory indexes and pretty URLs.
+	resp, _ = get("/about/", "gzip")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	// A variant older than its file is stale and never served.
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "main.css"), future, future))
+
+	resp, body = get("/main.css", "gzip, br")
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, css, body)
+}
+
+func TestWebsocketBuildEvents(t *testing.T) {
+	c := NewContext(&Args{Log: &Logger{Level: LevelWarn}})
+
+	var buildCompleteMu sync.Mutex
+	buildComplete := sync.NewCond(&buildCompleteMu)
+
+	server := httptest.NewServer(http.HandlerFunc(getWebsocketHandler(c, buildComplete)))
+	defer server.Close()
+
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(
+			"ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		assert.NoError(t, err)
+		return conn
+	}
+
+	failedEvent := &websocketEvent{
+		Errors: []*websocketEventError{{Job: "job 0", Message: "error"}},
+		Type:   websocketEventTypeBuildFailed,
+	}
+
+	// A client connecting while the last build is failed gets its errors
+	// immediately.
+	c.setLastBuildEvent(failedEvent)
+
+	conn := dial()
+	defer conn.Close()
+
+	var event websocketEvent
+	assert.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, failedEvent, &event)
+
+	// The next build succeeds. Broadcast until the write pump is waiting on
+	// the condition variable, which it may not be yet.
+	c.setLastBuildEvent(&websocketEvent{Type: websocketEventTypeBuildComplete})
+
+	received := make(chan websocketEvent, 1)
+	go func() {
+		var event websocketEvent
+		if err := conn.ReadJSON(&event); err == nil {
+			received <- event
+		}
+	}()
+
+	for {
+		buildComplete.Broadcast()
+
+		select {
+		case event := <-received:
+			assert.Equal(t, websocketEventTypeBuildComplete, event.Type)
+			assert.Empty(t, event.Errors)
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Package atomicfile writes files atomically. It's the implementation behind
+// mfile.WriteFileAtomic, and lives apart from mfile so that modulir itself,
+// which mfile imports, can use it too.
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+)
+
+// Write writes data to path with the given permissions by way of a uniquely
+// named temporary file in the same directory that's renamed into place, so
+// that readers never see a partially written file and concurrent writers of
+// the same path don't trample each other. The file's directory is created if
+// it doesn't exist.
+func Write(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return xerrors.Errorf("error creating directory: %w", err)
+	}
+
+	temp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return xerrors.Errorf("error creating temporary file: %w", err)
+	}
+
+	// A no-op once the file's been renamed into place.
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return xerrors.Errorf("error writing temporary file: %w", err)
+	}
+
+	if err := temp.Chmod(perm); err != nil {
+		temp.Close()
+		return xerrors.Errorf("error setting temporary file permissions: %w", err)
+	}
+
+	if err := temp.Close(); err != nil {
+		return xerrors.Errorf("error closing temporary file: %w", err)
+	}
+
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return xerrors.Errorf("error renaming temporary file: %w", err)
+	}
+
+	return nil
+}
@@ -8,7 +8,153 @@
 package modulir
 
 // Source: websocket.js
-const websocketJS = "function connect() {\n" +
+const websocketJS = "var overlayID = \"modulir-build-failed-overlay\";\n" +
+	"\n" +
+	"// Removes the build failed overlay if it's currently being shown.\n" +
+	"function hideBuildFailedOverlay() {\n" +
+	"  var overlay = document.getElementById(overlayID);\n" +
+	"  if (overlay) {\n" +
+	"    overlay.remove();\n" +
+	"  }\n" +
+	"}\n" +
+	"\n" +
+	"// Shows an overlay on top of the page listing the errors of a failed build.\n" +
+	"// The overlay replaces any existing one and can be dismissed.\n" +
+	"function showBuildFailedOverlay(errors) {\n" +
+	"  hideBuildFailedOverlay();\n" +
+	"\n" +
+	"  var overlay = document.createElement(\"div\");\n" +
+	"  overlay.id = overlayID;\n" +
+	"  overlay.style.cssText = \"position: fixed; inset: 0; z-index: 2147483647; \" +\n" +
+	"    \"overflow: auto; padding: 32px; background: rgba(20, 20, 20, 0.92); \" +\n" +
+	"    \"color: #eee; font: 14px/1.5 monospace; text-align: left;\";\n" +
+	"\n" +
+	"  var dismiss = document.createElement(\"button\");\n" +
+	"  dismiss.textContent = \"×\";\n" +
+	"  dismiss.title = \"Dismiss\";\n" +
+	"  dismiss.style.cssText = \"position: absolute; top: 16px; right: 24px; \" +\n" +
+	"    \"border: none; background: none; color: #eee; font-size: 32px; cursor: pointer;\";\n" +
+	"  dismiss.onclick = hideBuildFailedOverlay;\n" +
+	"  overlay.appendChild(dismiss);\n" +
+	"\n" +
+	"  var heading = document.createElement(\"h2\");\n" +
+	"  heading.textContent = `Build failed with ${errors.length} error(s)`;\n" +
+	"  heading.style.cssText = \"margin: 0 0 24px; color: #ff6b6b; font: bold 20px monospace;\";\n" +
+	"  overlay.appendChild(heading);\n" +
+	"\n" +
+	"  errors.forEach(function(error) {\n" +
+	"    if (error.job) {\n" +
+	"      var job = document.createElement(\"div\");\n" +
+	"      job.textContent = error.job;\n" +
+	"      job.style.cssText = \"font-weight: bold; color: #ffd166;\";\n" +
+	"      overlay.appendChild(job);\n" +
+	"    }\n" +
+	"\n" +
+	"    // Set as text rather than HTML because error messages often contain\n" +
+	"    // fragments of HTML templates.\n" +
+	"    var message = document.createElement(\"pre\");\n" +
+	"    message.textContent = error.message;\n" +
+	"    message.style.cssText = \"margin: 4px 0 24px; white-space: pre-wrap; \" +\n" +
+	"      \"word-break: break-word; font: inherit;\";\n" +
+	"    overlay.appendChild(message);\n" +
+	"  });\n" +
+	"\n" +
+	"  document.body.appendChild(overlay);\n" +
+	"}\n" +
+	"\n" +
+	"// Swaps in new versions of the stylesheets at the given URLs without reloading\n" +
+	"// the page. Each matching <link> is replaced by a copy with a cache-busting\n" +
+	"// query, and the old one is only removed once the new one has loaded so that\n" +
+	"// the page isn't briefly unstyled.\n" +
+	"function swapStylesheets(urls) {\n" +
+	"  var links = document.querySelectorAll(\"link[rel=stylesheet]\");\n" +
+	"\n" +
+	"  links.forEach(function(link) {\n" +
+	"    var url = new URL(link.href, location.href);\n" +
+	"    if (url.origin != location.origin || !urls.includes(url.pathname)) {\n" +
+	"      return;\n" +
+	"    }\n" +
+	"\n" +
+	"    url.searchParams.set(\"modulir\", Date.now());\n" +
+	"\n" +
+	"    var newLink = link.cloneNode();\n" +
+	"    newLink.href = url.toString();\n" +
+	"    newLink.onload = function() {\n" +
+	"      link.remove();\n" +
+	"    };\n" +
+	"    newLink.onerror = function() {\n" +
+	"      console.log(`Couldn't load stylesheet '${url}'; reloading page`);\n" +
+	"      location.reload(true);\n" +
+	"    };\n" +
+	"\n" +
+	"    link.after(newLink);\n" +
+	"    console.log(`Swapped stylesheet: ${url.pathname}`);\n" +
+	"  });\n" +
+	"}\n" +
+	"\n" +
+	"// Normalizes a URL path so that the different paths that a page is served on\n" +
+	"// compare equal: \"/about.html\", \"/about/\", and \"/about\" are all \"/about\", and\n" +
+	"// \"/index.html\" is \"/\".\n" +
+	"function normalizePath(path) {\n" +
+	"  path = path.replace(/\\.html$/, \"\").replace(/\\/index$/, \"/\");\n" +
+	"  if (path.length > 1 && path.endsWith(\"/\")) {\n" +
+	"    path = path.slice(0, -1);\n" +
+	"  }\n" +
+	"  return path;\n" +
+	"}\n" +
+	"\n" +
+	"// Whether the current page is being served the not found page because\n" +
+	"// nothing exists at its path. Checked once on load.\n" +
+	"var notFound = false;\n" +
+	"fetch(location.href, { method: \"HEAD\" }).then(function(response) {\n" +
+	"  notFound = response.status == 404;\n" +
+	"}).catch(function() {});\n" +
+	"\n" +
+	"// Returns the normalized paths of the current page and every same-origin\n" +
+	"// resource that it loads.\n" +
+	"function pagePaths() {\n" +
+	"  var paths = [normalizePath(location.pathname)];\n" +
+	"\n" +
+	"  // A page showing the not found page changes along with it.\n" +
+	"  if (notFound) {\n" +
+	"    paths.push(normalizePath(\"{{.NotFoundPath}}\"));\n" +
+	"  }\n" +
+	"\n" +
+	"  var addURL = function(value) {\n" +
+	"    var url = new URL(value, location.href);\n" +
+	"    if (url.origin == location.origin) {\n" +
+	"      paths.push(normalizePath(url.pathname));\n" +
+	"    }\n" +
+	"  };\n" +
+	"\n" +
+	"  document.querySelectorAll(\"link[href]\").forEach(function(el) {\n" +
+	"    addURL(el.getAttribute(\"href\"));\n" +
+	"  });\n" +
+	"  document.querySelectorAll(\"script[src], img[src], source[src], video[src]\").forEach(function(el) {\n" +
+	"    addURL(el.getAttribute(\"src\"));\n" +
+	"  });\n" +
+	"  document.querySelectorAll(\"img[srcset], source[srcset]\").forEach(function(el) {\n" +
+	"    el.getAttribute(\"srcset\").split(\",\").forEach(function(candidate) {\n" +
+	"      var url = candidate.trim().split(/\\s+/)[0];\n" +
+	"      if (url) {\n" +
+	"        addURL(url);\n" +
+	"      }\n" +
+	"    });\n" +
+	"  });\n" +
+	"\n" +
+	"  return paths;\n" +
+	"}\n" +
+	"\n" +
+	"// Whether any of the given paths written by a build are the current page or a\n" +
+	"// resource that it loads.\n" +
+	"function pageChanged(writtenPaths) {\n" +
+	"  var paths = pagePaths();\n" +
+	"  return writtenPaths.some(function(path) {\n" +
+	"    return paths.includes(normalizePath(path));\n" +
+	"  });\n" +
+	"}\n" +
+	"\n" +
+	"function connect() {\n" +
 	"  var url = \"ws://localhost:{{.Port}}/websocket\";\n" +
 	"\n" +
 	"  console.log(`Connecting to Modulir: ${url}`);\n" +
@@ -34,6 +180,14 @@ const websocketJS = "function connect() {\n" +
 	"\n" +
 	"    switch(data.type) {\n" +
 	"      case \"build_complete\":\n" +
+	"        // A successful build clears any errors from an earlier one.\n" +
+	"        hideBuildFailedOverlay();\n" +
+	"\n" +
+	"        if (!pageChanged(data.paths || [])) {\n" +
+	"          console.log(\"Page unaffected by build; not reloading\");\n" +
+	"          break;\n" +
+	"        }\n" +
+	"\n" +
 	"        // 1000 = \"Normal closure\" and the second parameter is a human-readable\n" +
 	"        // reason.\n" +
 	"        socket.close(1000, \"Reloading page after receiving build_complete\");\n" +
@@ -43,6 +197,23 @@ const websocketJS = "function connect() {\n" +
 	"\n" +
 	"        break;\n" +
 	"\n" +
+	"      case \"css_changed\":\n" +
+	"        // A successful build clears any errors from an earlier one.\n" +
+	"        hideBuildFailedOverlay();\n" +
+	"\n" +
+	"        console.log(\"Swapping stylesheets after receiving css_changed\");\n" +
+	"        swapStylesheets(data.urls || []);\n" +
+	"\n" +
+	"        break;\n" +
+	"\n" +
+	"      case \"build_failed\":\n" +
+	"        // Don't reload so that the page stays usable while the errors are\n" +
+	"        // fixed. The next successful build reloads it.\n" +
+	"        console.log(\"Showing errors after receiving build_failed\");\n" +
+	"        showBuildFailedOverlay(data.errors || []);\n" +
+	"\n" +
+	"        break;\n" +
+	"\n" +
 	"      default:\n" +
 	"        console.log(`Don't know how to handle type '${data.type}'`);\n" +
 	"    }\n" +
@@ -0,0 +1,263 @@
+package modulir
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Public
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// AddOutput records that the files at the given paths were produced in
+// TargetDir by owner, which is normally the name of the job that produced
+// them. Together, recorded outputs make up a build output manifest that's
+// used to delete orphaned files after a successful build.
+//
+// An owner's outputs are those it recorded on the last build where it
+// recorded any. An owner that's neither enqueued as a job nor records any
+// outputs during a build is considered removed, and all of its outputs are
+// deleted (unless another owner claims them). This means that jobs can skip
+// recording outputs when they have no work to do, but that owners that aren't
+// jobs must record all their outputs on every build.
+//
+// Files that were never recorded are never deleted. Paths outside of TargetDir
+// are ignored.
+func (c *Context) AddOutput(owner string, paths ...string) {
+	relPaths := make([]string, 0, len(paths))
+	for _, p := range paths {
+		relPath, err := filepath.Rel(c.TargetDir, p)
+		if err != nil || relPath == "." || strings.HasPrefix(relPath, "..") {
+			c.Log.Warnf("Ignoring output outside of target directory: %s", p)
+			continue
+		}
+		relPaths = append(relPaths, relPath)
+	}
+
+	if len(relPaths) < 1 {
+		return
+	}
+
+	c.outputManifest.addOutputs(owner, relPaths)
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Deletes files in TargetDir that were produced by owners that no longer
+// exist, or that are no longer produced by the owners that still do. Should
+// only be called after a successful build. Directories left empty by deletions
+// are removed as well.
+func (c *Context) pruneOutputs() {
+	orphans := c.outputManifest.finish()
+
+	for _, relPath := range orphans {
+		target := filepath.Join(c.TargetDir, relPath)
+
+		if err := os.Remove(target); err != nil {
+			if !os.IsNotExist(err) {
+				c.Log.Errorf("Error removing stale output: %v",
+					xerrors.Errorf("error removing '%s': %w", target, err))
+			}
+			continue
+		}
+
+		c.Log.Infof("Removed stale output: %s", target)
+		c.MarkWritten(target)
+
+		// Walk up removing directories until reaching one that isn't empty
+		// (in which case os.Remove fails) or TargetDir itself.
+		for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+			if err := os.Remove(filepath.Join(c.TargetDir, dir)); err != nil {
+				break
+			}
+		}
+	}
+}
+
+// outputManifest tracks which owners (usually jobs) produced which files in
+// the target directory.
+type outputManifest struct {
+	// live is the set of owners enqueued as jobs during the current build.
+	live map[string]struct{}
+
+	// mu synchronizes access to all fields.
+	mu sync.Mutex
+
+	// owners maps owners to the paths (relative to the target directory)
+	// that they produced, as of the last successful build.
+	owners map[string][]string
+
+	// ownersNew maps owners to the paths that they've recorded during the
+	// current build.
+	ownersNew map[string]map[string]struct{}
+}
+
+func newOutputManifest() *outputManifest {
+	return &outputManifest{
+		live:      make(map[string]struct{}),
+		owners:    make(map[string][]string),
+		ownersNew: make(map[string]map[string]struct{}),
+	}
+}
+
+func (m *outputManifest) addOutputs(owner string, relPaths []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paths, ok := m.ownersNew[owner]
+	if !ok {
+		paths = make(map[string]struct{})
+		m.ownersNew[owner] = paths
+	}
+
+	for _, p := range relPaths {
+		paths[p] = struct{}{}
+	}
+}
+
+// Abandons the current build's bookkeeping after a build that wasn't
+// successful. Outputs recorded during the build are merged into the existing
+// ones rather than replacing them so that any files that were superseded will
+// still be pruned after the next successful build.
+func (m *outputManifest) abandon() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for owner, paths := range m.ownersNew {
+		merged := make(map[string]struct{}, len(paths))
+		for _, p := range m.owners[owner] {
+			merged[p] = struct{}{}
+		}
+		for p := range paths {
+			merged[p] = struct{}{}
+		}
+		m.owners[owner] = sortedMapKeys(merged)
+	}
+
+	m.reset()
+}
+
+// Finishes the current build, replacing the outputs of any owners that
+// recorded outputs and dropping owners that weren't live. Returns the paths
+// of all outputs that are no longer claimed by any owner.
+func (m *outputManifest) finish() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	owners := make(map[string][]string, len(m.owners))
+	claimed := make(map[string]struct{})
+
+	for owner, paths := range m.ownersNew {
+		owners[owner] = sortedMapKeys(paths)
+		for p := range paths {
+			claimed[p] = struct{}{}
+		}
+	}
+
+	for owner, paths := range m.owners {
+		if _, ok := owners[owner]; ok {
+			continue
+		}
+		if _, ok := m.live[owner]; !ok {
+			continue
+		}
+
+		owners[owner] = paths
+		for _, p := range paths {
+			claimed[p] = struct{}{}
+		}
+	}
+
+	orphansSet := make(map[string]struct{})
+	for _, paths := range m.owners {
+		for _, p := range paths {
+			if _, ok := claimed[p]; !ok {
+				orphansSet[p] = struct{}{}
+			}
+		}
+	}
+
+	m.owners = owners
+	m.reset()
+
+	return sortedMapKeys(orphansSet)
+}
+
+// Loads outputs as of the last successful build from the persistent cache.
+func (m *outputManifest) load(owners map[string][]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for owner, paths := range owners {
+		m.owners[owner] = paths
+	}
+}
+
+// Looks for an output as of the last successful build that no longer exists
+// in targetDir. Returns its path and true if there is one.
+func (m *outputManifest) findMissing(targetDir string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, paths := range m.owners {
+		for _, relPath := range paths {
+			path := filepath.Join(targetDir, relPath)
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				return path, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func (m *outputManifest) markLive(owner string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.live[owner] = struct{}{}
+}
+
+// Resets bookkeeping for the current build. Expects mu to be held.
+func (m *outputManifest) reset() {
+	m.live = make(map[string]struct{})
+	m.ownersNew = make(map[string]map[string]struct{})
+}
+
+// Returns a copy of outputs as of the last successful build suitable for
+// encoding into the persistent cache.
+func (m *outputManifest) snapshot() map[string][]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	owners := make(map[string][]string, len(m.owners))
+	for owner, paths := range m.owners {
+		owners[owner] = paths
+	}
+	return owners
+}
+
+func sortedMapKeys(m map[string]struct{}) []string {
+	keys := mapKeys(m)
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,95 @@
+package modulir
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestPruneOutputs(t *testing.T) {
+	dir := t.TempDir()
+
+	c := NewContext(&Args{
+		Log:       &Logger{Level: LevelWarn},
+		TargetDir: dir,
+	})
+
+	write := func(relPath string) string {
+		target := filepath.Join(dir, relPath)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(target), 0o755))
+		assert.NoError(t, os.WriteFile(target, []byte("hello"), 0o600))
+		return target
+	}
+
+	exists := func(relPath string) bool {
+		_, err := os.Stat(filepath.Join(dir, relPath))
+		return err == nil
+	}
+
+	// First build: everything is new.
+	c.outputManifest.markLive("article: a")
+	c.AddOutput("article: a", write("a.html"))
+	c.outputManifest.markLive("article: b")
+	c.AddOutput("article: b", write("b.html"))
+	c.AddOutput("images: b", write("images/b/1.png"), write("images/b/2.png"))
+	c.outputManifest.markLive("sitemap")
+	c.AddOutput("sitemap", write("sitemap.xml"), write("sitemap-1.xml"))
+	c.pruneOutputs()
+
+	// Never recorded, so never pruned.
+	write("unrecorded.html")
+	write("images/unrecorded.png")
+
+	// Second build: article a's job is enqueued but has no work to do,
+	// article b was removed, and the sitemap shrank.
+	c.outputManifest.markLive("article: a")
+	c.outputManifest.markLive("sitemap")
+	c.AddOutput("sitemap", write("sitemap.xml"))
+	c.pruneOutputs()
+
+	assert.True(t, exists("a.html"))
+	assert.False(t, exists("b.html"))
+	assert.False(t, exists("images/b/1.png"))
+	assert.False(t, exists("images/b"))
+	assert.True(t, exists("images"))
+	assert.True(t, exists("sitemap.xml"))
+	assert.False(t, exists("sitemap-1.xml"))
+	assert.True(t, exists("unrecorded.html"))
+
+	// A failed build doesn't prune anything, and because not all jobs may
+	// have been enqueued, doesn't consider anything removed.
+	c.outputManifest.markLive("article: c")
+	c.AddOutput("article: c", write("c.html"))
+	c.outputManifest.abandon()
+
+	// Third build: article a renamed to c, which now claims a.html as well.
+	c.outputManifest.markLive("article: c")
+	c.AddOutput("article: c", write("a.html"))
+	c.outputManifest.markLive("sitemap")
+	c.pruneOutputs()
+
+	assert.True(t, exists("a.html"))
+	assert.False(t, exists("c.html"))
+	assert.True(t, exists("sitemap.xml"))
+
+	assert.Equal(t, map[string][]string{
+		"article: c": {"a.html"},
+		"sitemap":    {"sitemap.xml"},
+	}, c.outputManifest.snapshot())
+}
+
+func TestAddOutputOutsideTargetDir(t *testing.T) {
+	dir := t.TempDir()
+
+	c := NewContext(&Args{
+		Log:       &Logger{Level: LevelError},
+		TargetDir: filepath.Join(dir, "public"),
+	})
+
+	c.AddOutput("job", filepath.Join(dir, "outside.html"), filepath.Join(dir, "public"))
+	c.pruneOutputs()
+
+	assert.Empty(t, c.outputManifest.snapshot())
+}
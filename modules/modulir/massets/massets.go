@@ -0,0 +1,167 @@
+// Package massets fingerprints static assets by copying them to names that
+// include a hash of their contents, so that browsers and CDNs can cache them
+// indefinitely and still pick up changes as soon as they're deployed.
+package massets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/xerrors"
+
+	"coolstercodes/modules/modulir/mfile"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Public
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// FuncMap is a set of helper functions to make available in templates for the
+// project.
+var FuncMap = template.FuncMap{
+	"Asset": Asset,
+}
+
+// Asset returns the URL of the named asset (e.g. `javascripts/main.js`) from
+// the manifest set with SetManifest. It's an error for the asset not to be in
+// the manifest so that a mistyped name fails the build instead of producing a
+// broken link.
+func Asset(name string) (string, error) {
+	m := currentManifest.Load()
+	if m == nil {
+		return "", xerrors.Errorf("no asset manifest set looking up asset '%s'", name)
+	}
+
+	return m.URL(name)
+}
+
+// Fingerprint copies source into targetDir under a name that includes a hash
+// of its contents (`main.js` becomes something like `main.0123456789.js`) and
+// returns that name. Because the name changes along with the contents, the
+// copy is skipped if a file with the name already exists.
+func Fingerprint(source, targetDir string) (string, error) {
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return "", xerrors.Errorf("error reading asset: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])[0:hashLength]
+
+	base := filepath.Base(source)
+	ext := filepath.Ext(base)
+	name := strings.TrimSuffix(base, ext) + "." + hash + ext
+
+	target := filepath.Join(targetDir, name)
+	if _, err := os.Stat(target); err == nil {
+		return name, nil
+	}
+
+	if err := mfile.WriteFileAtomic(target, data, 0o644); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// SetManifest sets the manifest that Asset looks up URLs in.
+func SetManifest(m *Manifest) {
+	currentManifest.Store(m)
+}
+
+// Manifest maps the names of assets (their paths relative to the directory
+// they're served from, like `javascripts/main.js`) to the URLs that they're
+// served at.
+type Manifest struct {
+	mu   sync.RWMutex
+	urls map[string]string
+}
+
+// NewManifest returns a new, empty manifest.
+func NewManifest() *Manifest {
+	return &Manifest{urls: make(map[string]string)}
+}
+
+// Add adds an asset to the manifest, replacing any existing URL for it.
+func (m *Manifest) Add(name, url string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.urls[name] = url
+}
+
+// MarshalJSON encodes the manifest as an object mapping asset names to URLs.
+func (m *Manifest) MarshalJSON() ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return json.Marshal(m.urls)
+}
+
+// URL returns the URL of the named asset.
+func (m *Manifest) URL(name string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	url, ok := m.urls[name]
+	if !ok {
+		return "", xerrors.Errorf("asset not found in manifest: '%s'", name)
+	}
+
+	return url, nil
+}
+
+// Write writes the manifest to path as JSON. The file is left untouched if
+// its contents wouldn't change so that its modification time can be used to
+// tell whether any asset did. Returns whether the file was written.
+func (m *Manifest) Write(path string) (bool, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return false, xerrors.Errorf("error marshaling asset manifest: %w", err)
+	}
+	data = append(data, '\n')
+
+	existing, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(existing, data) {
+		return false, nil
+	}
+
+	if err := mfile.WriteFileAtomic(path, data, 0o644); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Number of characters of a hex-encoded SHA-256 hash included in fingerprinted
+// names. It's short to keep names readable, but long enough that collisions
+// between versions of the same asset aren't a concern.
+const hashLength = 10
+
+// The manifest used by Asset. It's global because template functions don't
+// have any other way of getting at it.
+var currentManifest atomic.Pointer[Manifest]
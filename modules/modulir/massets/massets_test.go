@@ -0,0 +1,95 @@
+package massets
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestAsset(t *testing.T) {
+	defer SetManifest(nil)
+
+	SetManifest(nil)
+	_, err := Asset("javascripts/main.js")
+	assert.EqualError(t, err, "no asset manifest set looking up asset 'javascripts/main.js'")
+
+	m := NewManifest()
+	m.Add("javascripts/main.js", "/content/javascripts/main.0123456789.js")
+	SetManifest(m)
+
+	url, err := Asset("javascripts/main.js")
+	assert.NoError(t, err)
+	assert.Equal(t, "/content/javascripts/main.0123456789.js", url)
+
+	_, err = Asset("javascripts/missing.js")
+	assert.EqualError(t, err, "asset not found in manifest: 'javascripts/missing.js'")
+}
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	targetDir := t.TempDir()
+
+	source := path.Join(dir, "main.min.js")
+	assert.NoError(t, os.WriteFile(source, []byte("console.log('hello');"), 0o600))
+
+	name, err := Fingerprint(source, targetDir)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^main\.min\.[0-9a-f]{10}\.js$`, name)
+
+	data, err := os.ReadFile(path.Join(targetDir, name))
+	assert.NoError(t, err)
+	assert.Equal(t, "console.log('hello');", string(data))
+
+	// The same contents produce the same name.
+	sameName, err := Fingerprint(source, targetDir)
+	assert.NoError(t, err)
+	assert.Equal(t, name, sameName)
+
+	// Different contents produce a different name.
+	assert.NoError(t, os.WriteFile(source, []byte("console.log('goodbye');"), 0o600))
+
+	newName, err := Fingerprint(source, targetDir)
+	assert.NoError(t, err)
+	assert.NotEqual(t, name, newName)
+}
+
+func TestManifestWrite(t *testing.T) {
+	manifestPath := path.Join(t.TempDir(), "assets.json")
+
+	m := NewManifest()
+	m.Add("stylesheets/main.css", "/content/stylesheets/main.0123456789.css")
+	m.Add("javascripts/main.js", "/content/javascripts/main.0123456789.js")
+
+	written, err := m.Write(manifestPath)
+	assert.NoError(t, err)
+	assert.True(t, written)
+
+	data, err := os.ReadFile(manifestPath)
+	assert.NoError(t, err)
+	assert.Equal(t, `{
+  "javascripts/main.js": "/content/javascripts/main.0123456789.js",
+  "stylesheets/main.css": "/content/stylesheets/main.0123456789.css"
+}
+`, string(data))
+
+	// Writing an unchanged manifest leaves the file alone.
+	past := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(manifestPath, past, past))
+
+	written, err = m.Write(manifestPath)
+	assert.NoError(t, err)
+	assert.False(t, written)
+
+	info, err := os.Stat(manifestPath)
+	assert.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(past))
+
+	m.Add("javascripts/main.js", "/content/javascripts/main.abcdef0123.js")
+
+	written, err = m.Write(manifestPath)
+	assert.NoError(t, err)
+	assert.True(t, written)
+}
@@ -0,0 +1,123 @@
+// Package matom provides a minimal set of types for producing Atom feeds.
+package matom
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Public
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Feed represents an Atom feed.
+type Feed struct {
+	XMLName xml.Name `xml:"feed"`
+
+	// Entries are the feed's entries, usually ordered most recent first.
+	Entries []*Entry `xml:"entry"`
+
+	// ID is a permanent, universally unique identifier for the feed.
+	ID string `xml:"id"`
+
+	// Links are links to the feed itself and to its alternate HTML version.
+	Links []*Link `xml:"link"`
+
+	// Title is a human-readable title for the feed.
+	Title string `xml:"title"`
+
+	// Updated is the last time the feed was modified in a significant way.
+	// Generally this is the publish time of its most recent entry.
+	Updated time.Time `xml:"updated"`
+
+	XMLLang string `xml:"xml:lang,attr"`
+	XMLNS   string `xml:"xmlns,attr"`
+}
+
+// Encode encodes the feed as XML to the given writer. Indent may be empty to
+// produce compact output.
+func (f *Feed) Encode(w io.Writer, indent string) error {
+	f.XMLLang = "en-US"
+	f.XMLNS = "http://www.w3.org/2005/Atom"
+
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return xerrors.Errorf("error writing XML header: %w", err)
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", indent)
+	if err := encoder.Encode(f); err != nil {
+		return xerrors.Errorf("error encoding feed: %w", err)
+	}
+
+	// The encoder doesn't leave a trailing newline.
+	if _, err := w.Write([]byte("\n")); err != nil {
+		return xerrors.Errorf("error writing trailing newline: %w", err)
+	}
+
+	return nil
+}
+
+// Entry represents a single entry in an Atom feed.
+type Entry struct {
+	// Author is the author of the entry.
+	Author *Author `xml:"author,omitempty"`
+
+	// Categories are categories (i.e. tags) that the entry belongs to.
+	Categories []*Category `xml:"category,omitempty"`
+
+	// Content is the full content of the entry.
+	Content *EntryContent `xml:"content"`
+
+	// ID is a permanent, universally unique identifier for the entry.
+	ID string `xml:"id"`
+
+	// Link is a link to the HTML version of the entry.
+	Link *Link `xml:"link"`
+
+	// Published is the time that the entry was first published.
+	Published time.Time `xml:"published"`
+
+	// Summary is a short summary of the entry. It's optional.
+	Summary *EntryContent `xml:"summary,omitempty"`
+
+	// Title is a human-readable title for the entry.
+	Title string `xml:"title"`
+
+	// Updated is the last time the entry was modified in a significant way.
+	Updated time.Time `xml:"updated"`
+}
+
+// Author represents the author of an entry.
+type Author struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+// Category represents a category (i.e. a tag) applied to an entry.
+type Category struct {
+	Term string `xml:"term,attr"`
+}
+
+// EntryContent represents the content or summary of an entry along with its
+// type. Type should usually be "html".
+type EntryContent struct {
+	Content string `xml:",chardata"`
+	Type    string `xml:"type,attr"`
+}
+
+// Link represents a link to a resource from a feed or entry.
+type Link struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
@@ -0,0 +1,59 @@
+package matom
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestFeedEncode(t *testing.T) {
+	publishedAt := time.Date(2024, 5, 2, 18, 33, 36, 0, time.UTC)
+
+	feed := &Feed{
+		ID:    "tag:example.com,2024:/articles",
+		Title: "Articles",
+		Links: []*Link{
+			{Rel: "self", Type: "application/atom+xml", Href: "https://example.com/articles.atom"},
+		},
+		Updated: publishedAt,
+		Entries: []*Entry{
+			{
+				Author:     &Author{Name: "Me", URI: "https://example.com"},
+				Categories: []*Category{{Term: "Science"}},
+				Content:    &EntryContent{Content: "<p>Hello & welcome</p>", Type: "html"},
+				ID:         "tag:example.com,2024-05-02:euclid",
+				Link:       &Link{Href: "https://example.com/euclid"},
+				Published:  publishedAt,
+				Title:      "Euclid",
+				Updated:    publishedAt,
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, feed.Encode(&buf, "  "))
+
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<feed xml:lang="en-US" xmlns="http://www.w3.org/2005/Atom">
+  <entry>
+    <author>
+      <name>Me</name>
+      <uri>https://example.com</uri>
+    </author>
+    <category term="Science"></category>
+    <content type="html">&lt;p&gt;Hello &amp; welcome&lt;/p&gt;</content>
+    <id>tag:example.com,2024-05-02:euclid</id>
+    <link href="https://example.com/euclid"></link>
+    <published>2024-05-02T18:33:36Z</published>
+    <title>Euclid</title>
+    <updated>2024-05-02T18:33:36Z</updated>
+  </entry>
+  <id>tag:example.com,2024:/articles</id>
+  <link href="https://example.com/articles.atom" rel="self" type="application/atom+xml"></link>
+  <title>Articles</title>
+  <updated>2024-05-02T18:33:36Z</updated>
+</feed>
+`, buf.String())
+}
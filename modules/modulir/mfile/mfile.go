@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 	"golang.org/x/xerrors"
 
 	"coolstercodes/modules/modulir"
+	"coolstercodes/modules/modulir/internal/atomicfile"
 )
 
 //////////////////////////////////////////////////////////////////////////////
@@ -25,13 +27,45 @@ import (
 //////////////////////////////////////////////////////////////////////////////
 
 // CopyDirectoryImages is a shortcut for copying over all non-md files into the /public/images/<identifier>/.
+//
+// Files that haven't changed since they were last copied are skipped as long
+// as their copy still exists in the target.
+//
+// All copies are recorded as outputs (see modulir.Context.AddOutput) owned by
+// their source directory, so copies of files that were removed from a source
+// directory, or of a whole source directory that was removed, are pruned after
+// a successful build.
 func CopyDirectoryImages(c *modulir.Context, source, target string) error {
+	return CopyDirectoryImagesWithOptions(c, source, target, nil)
+}
+
+// CopyDirectoryImagesOptions are options for CopyDirectoryImagesWithOptions.
+type CopyDirectoryImagesOptions struct {
+	// ExcludeDirs are the names of subdirectories of the source (like
+	// `my-article`) that aren't copied. Copies made of them by previous
+	// builds are pruned like those of removed directories.
+	ExcludeDirs []string
+}
+
+// CopyDirectoryImagesWithOptions is the same as CopyDirectoryImages, but
+// allows options to be specified.
+func CopyDirectoryImagesWithOptions(c *modulir.Context, source, target string,
+	opts *CopyDirectoryImagesOptions,
+) error {
+	if opts == nil {
+		opts = &CopyDirectoryImagesOptions{}
+	}
+
 	dirs, err := ReadDirWithOptions(c, source, &ReadDirOptions{ShowDirs: true})
 	if err != nil {
 		return err
 	}
 
 	for _, dir := range dirs {
+		if slices.Contains(opts.ExcludeDirs, filepath.Base(dir)) {
+			continue
+		}
+
 		// Read the files from that dir ignoring *.md
 		files, err := ReadDirWithOptions(c, dir, &ReadDirOptions{IgnoreMDs: true})
 		if err != nil {
@@ -49,6 +83,15 @@ func CopyDirectoryImages(c *modulir.Context, source, target string) error {
 
 		// Copy all files into there
 		for _, file := range files {
+			targetFile := path.Join(targetDir, filepath.Base(file))
+			c.AddOutput("images: "+dir, targetFile)
+
+			// Make sure Changed comes first so that the file is always
+			// tracked (and watched).
+			if !c.Changed(file) && Exists(targetFile) {
+				continue
+			}
+
 			if err = CopyFileToDir(c, file, targetDir); err != nil {
 				return err
 			}
@@ -76,6 +119,7 @@ func CopyFile(c *modulir.Context, source, target string) error {
 		return xerrors.Errorf("error copying data: %w", err)
 	}
 
+	c.MarkWritten(target)
 	c.Log.Debugf("mfile: Copied '%s' to '%s'", source, target)
 	return nil
 }
@@ -86,6 +130,35 @@ func CopyFileToDir(c *modulir.Context, source, targetDir string) error {
 	return CopyFile(c, source, path.Join(targetDir, filepath.Base(source)))
 }
 
+// EnsureCopy ensures that target is a regular file containing a copy of
+// source. Like CopyDirectoryImages, the copy is skipped if source hasn't
+// changed since it was last copied and its copy still exists. A symbolic link
+// at target (like one left by EnsureSymlink) is replaced with a copy.
+func EnsureCopy(c *modulir.Context, source, target string) error {
+	info, err := os.Lstat(target)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return xerrors.Errorf("error checking copy target: %w", err)
+
+	case info.Mode()&os.ModeSymlink != 0:
+		// Remove the link first, or the copy would be written through it
+		// and over the source.
+		if err := os.Remove(target); err != nil {
+			return xerrors.Errorf("error removing symlink: %w", err)
+		}
+		info = nil
+	}
+
+	// Make sure Changed comes first so that the file is always tracked (and
+	// watched).
+	if !c.Changed(source) && info != nil && info.Mode().IsRegular() {
+		return nil
+	}
+
+	return CopyFile(c, source, target)
+}
+
 // EnsureDir ensures the existence of a target directory.
 func EnsureDir(c *modulir.Context, target string) error {
 	err := os.MkdirAll(target, 0o755)
@@ -104,8 +177,15 @@ func EnsureSymlink(c *modulir.Context, source, target string) error {
 		path.Base(source), source, target)
 
 	var actual string
+	var info os.FileInfo
+
+	// Links are created with absolute paths, so compare against one.
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return xerrors.Errorf("error getting absolute path for '%s': %w", source, err)
+	}
 
-	_, err := os.Stat(target)
+	_, err = os.Stat(target)
 
 	// Note that if a symlink file does exist, but points to a non-existent
 	// location, we still get an "does not exist" error back, so we fall down
@@ -122,6 +202,16 @@ func EnsureSymlink(c *modulir.Context, source, target string) error {
 		return xerrors.Errorf("error checking symlink: %w", err)
 	}
 
+	// A regular file (like a copy left by EnsureCopy) rather than a link.
+	info, err = os.Lstat(target)
+	if err != nil {
+		return xerrors.Errorf("error checking symlink: %w", err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		c.Log.Debugf("Destination is not a link. Creating.")
+		goto create
+	}
+
 	actual, err = os.Readlink(target)
 	if err != nil {
 		return xerrors.Errorf("error reading symlink: %w", err)
@@ -140,11 +230,6 @@ create:
 		return xerrors.Errorf("error removing symlink: %w", err)
 	}
 
-	source, err = filepath.Abs(source)
-	if err != nil {
-		return xerrors.Errorf("error getting absolute path for '%s': %w", source, err)
-	}
-
 	target, err = filepath.Abs(target)
 	if err != nil {
 		return xerrors.Errorf("error getting absolute path for '%s': %w", target, err)
@@ -158,6 +243,58 @@ create:
 	return nil
 }
 
+// FilterOptions are options for FilterFiles.
+type FilterOptions struct {
+	// Exclude are patterns (in the syntax of filepath.Match) matched against
+	// the base names of files. Files that match any of them are excluded.
+	Exclude []string
+
+	// ExcludeUnminified excludes files that have a minified duplicate in the
+	// same directory that's also in the list, like `main.css` when there's
+	// also a `main.min.css`.
+	ExcludeUnminified bool
+}
+
+// FilterFiles returns the paths in files that aren't excluded by opts, in the
+// same order.
+func FilterFiles(files []string, opts *FilterOptions) ([]string, error) {
+	if opts == nil {
+		opts = &FilterOptions{}
+	}
+
+	present := make(map[string]struct{}, len(files))
+	for _, file := range files {
+		present[file] = struct{}{}
+	}
+
+	filtered := make([]string, 0, len(files))
+
+outer:
+	for _, file := range files {
+		base := filepath.Base(file)
+
+		for _, pattern := range opts.Exclude {
+			matched, err := filepath.Match(pattern, base)
+			if err != nil {
+				return nil, xerrors.Errorf("error matching pattern '%s': %w", pattern, err)
+			}
+			if matched {
+				continue outer
+			}
+		}
+
+		if ext := filepath.Ext(file); opts.ExcludeUnminified && ext != "" {
+			if _, ok := present[strings.TrimSuffix(file, ext)+".min"+ext]; ok {
+				continue
+			}
+		}
+
+		filtered = append(filtered, file)
+	}
+
+	return filtered, nil
+}
+
 // IsMD indicates if the file is markdown.
 func IsMD(base string) bool {
 	return strings.HasSuffix(base, ".md")
@@ -246,9 +383,10 @@ type ReadDirOptions struct {
 }
 
 // ReadDirCached is the same as ReadDirWithOptions, but it caches results for
-// some amount of time to make it faster. The downside of this of course is
-// that we occasionally get a stale cache when a new file is added and don't
-// see it.
+// some amount of time to make it faster. The cache is bypassed when the build
+// loop was triggered by a file being added to or removed from source, but
+// otherwise the downside of this of course is that we occasionally get a stale
+// cache when a new file is added and don't see it.
 func ReadDirCached(c *modulir.Context, source string,
 	opts *ReadDirOptions,
 ) ([]string, error) {
@@ -262,8 +400,10 @@ func ReadDirCached(c *modulir.Context, source string,
 	// this project that ReadDir on particular directories always use the same
 	// options, so we let that slide even if it's somewhat dangerous.
 	if paths, ok := readDirCache.Get(source); ok {
-		c.Log.Debugf("Using cached results of ReadDir: %s", source)
-		return paths.([]string), nil
+		if !quickPathsChangeListing(c, source, paths.([]string)) {
+			c.Log.Debugf("Using cached results of ReadDir: %s", source)
+			return paths.([]string), nil
+		}
 	}
 
 	files, err := ReadDirWithOptions(c, source, opts)
@@ -338,6 +478,15 @@ func ReadDirWithOptions(c *modulir.Context, source string,
 	return files, nil
 }
 
+// WriteFileAtomic writes data to path with the given permissions by way of a
+// uniquely named temporary file in the same directory that's renamed into
+// place, so that readers never see a partially written file and concurrent
+// writers of the same path don't trample each other. The file's directory is
+// created if it doesn't exist.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return atomicfile.Write(path, data, perm)
+}
+
 //////////////////////////////////////////////////////////////////////////////
 //
 //
@@ -348,6 +497,47 @@ func ReadDirWithOptions(c *modulir.Context, source string,
 //
 //////////////////////////////////////////////////////////////////////////////
 
+// Returns true if any of the context's quick paths (i.e. paths that triggered
+// the current build loop) are within source and would change its listing,
+// meaning that they're either missing from it (the file was added) or no
+// longer exist (the file was removed).
+func quickPathsChangeListing(c *modulir.Context, source string, paths []string) bool {
+	if len(c.QuickPaths) < 1 {
+		return false
+	}
+
+	absSource, err := filepath.Abs(source)
+	if err != nil {
+		return true
+	}
+
+	listed := make(map[string]struct{}, len(paths))
+	for _, p := range paths {
+		absPath, err := filepath.Abs(p)
+		if err != nil {
+			return true
+		}
+		listed[absPath] = struct{}{}
+	}
+
+	for quickPath := range c.QuickPaths {
+		absPath, err := filepath.Abs(quickPath)
+		if err != nil {
+			return true
+		}
+
+		if !strings.HasPrefix(absPath, absSource+string(filepath.Separator)) {
+			continue
+		}
+
+		if _, ok := listed[absPath]; !ok || !Exists(absPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // An expiring cache that stores the results of a `mfile.ReadDir` (i.e. list
 // directory) for some period of time. It turns out these calls are relatively
 // slow and this helps speed up the build loop.
@@ -0,0 +1,38 @@
+package mfile
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestFilterFiles(t *testing.T) {
+	files := []string{
+		"web/javascripts/app.js",
+		"web/javascripts/app.js.map",
+		"web/javascripts/vendor.js",
+		"web/javascripts/vendor.min.js",
+		"web/javascripts/vendor.min.js.map",
+		"web/stylesheets/vendor.css",
+		"web/LICENSE",
+	}
+
+	filtered, err := FilterFiles(files, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, files, filtered)
+
+	filtered, err = FilterFiles(files, &FilterOptions{
+		Exclude:           []string{"*.map"},
+		ExcludeUnminified: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"web/javascripts/app.js",
+		"web/javascripts/vendor.min.js",
+		"web/stylesheets/vendor.css",
+		"web/LICENSE",
+	}, filtered)
+
+	_, err = FilterFiles(files, &FilterOptions{Exclude: []string{"["}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,532 @@
+// Package mimage produces resized variants of images so that pages can offer
+// browsers smaller versions of them with `srcset`, along with tiny
+// placeholders to show while they load.
+package mimage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/xerrors"
+
+	"coolstercodes/modules/modulir/mfile"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Public
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Info is information about an image that determines which variants of it can
+// be produced.
+type Info struct {
+	// Animated is whether the image is an animated GIF. These aren't resized
+	// because their variants would lose the animation.
+	Animated bool
+
+	// Height is the height of the image in pixels.
+	Height int
+
+	// Rotated is whether the image is a JPEG with an EXIF orientation other
+	// than the default. Browsers rotate these for display, but decoding
+	// doesn't, so they aren't resized.
+	Rotated bool
+
+	// Unrecognized is whether the image's contents aren't in a format that
+	// can be decoded (like an AVIF with a `.jpg` extension). These aren't
+	// resized either.
+	Unrecognized bool
+
+	// Width is the width of the image in pixels.
+	Width int
+}
+
+// VariantWidths returns the widths among widths at which variants of the
+// image should be produced, which are those narrower than the image itself.
+// Returns nothing if the image can't be resized.
+func (i *Info) VariantWidths(widths []int) []int {
+	if i.Animated || i.Rotated || i.Unrecognized {
+		return nil
+	}
+
+	var variantWidths []int
+	for _, width := range widths {
+		if width < i.Width {
+			variantWidths = append(variantWidths, width)
+		}
+	}
+	return variantWidths
+}
+
+// Options are options for WriteVariants.
+type Options struct {
+	// CacheDir is a directory where variants are cached by the hash of their
+	// source's contents so that they're only generated again when the source
+	// changes. Variants aren't cached if it's empty.
+	CacheDir string
+
+	// JPEGQuality is the quality that JPEG variants are encoded at. Defaults
+	// to DefaultJPEGQuality.
+	JPEGQuality int
+}
+
+// DefaultJPEGQuality is the quality that JPEG variants are encoded at by
+// default.
+const DefaultJPEGQuality = 82
+
+// PlaceholderWidth is the width in pixels of the placeholders produced by
+// Placeholder. Browsers scale them up to the size of the image that they
+// stand in for, which blurs them.
+const PlaceholderWidth = 16
+
+// IsSupported returns whether variants can be produced for the image at path
+// based on its extension.
+func IsSupported(path string) bool {
+	_, ok := variantExts[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// Placeholder returns a tiny version of the image at source as a data URI
+// that can be shown in its place while it loads. Like variants, placeholders
+// are cached in CacheDir by the hash of their source, and also in memory for
+// as long as the source's modification time and size don't change.
+//
+// An empty string is returned for images that are animated, rotated, or in an
+// unrecognized format, which don't get variants either (see
+// Info.VariantWidths), and for those with any transparency, through which the
+// placeholder would show once the image had loaded.
+func Placeholder(source string, opts *Options) (string, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	stat, err := os.Stat(source)
+	if err != nil {
+		return "", xerrors.Errorf("error checking image: %w", err)
+	}
+
+	placeholderCacheMu.Lock()
+	cached, ok := placeholderCache[source]
+	placeholderCacheMu.Unlock()
+
+	if ok && cached.modTime.Equal(stat.ModTime()) && cached.size == stat.Size() {
+		return cached.uri, nil
+	}
+
+	uri, err := readPlaceholder(source, opts)
+	if err != nil {
+		return "", err
+	}
+
+	placeholderCacheMu.Lock()
+	placeholderCache[source] = &cachedPlaceholder{uri: uri, modTime: stat.ModTime(), size: stat.Size()}
+	placeholderCacheMu.Unlock()
+
+	return uri, nil
+}
+
+// ReadInfo reads information about the image at source. Results are cached
+// in memory for as long as the file's modification time and size don't
+// change because rendering looks up the same images repeatedly.
+func ReadInfo(source string) (*Info, error) {
+	stat, err := os.Stat(source)
+	if err != nil {
+		return nil, xerrors.Errorf("error checking image: %w", err)
+	}
+
+	infoCacheMu.Lock()
+	cached, ok := infoCache[source]
+	infoCacheMu.Unlock()
+
+	if ok && cached.modTime.Equal(stat.ModTime()) && cached.size == stat.Size() {
+		return cached.info, nil
+	}
+
+	info, err := readInfo(source)
+	if err != nil {
+		return nil, err
+	}
+
+	infoCacheMu.Lock()
+	infoCache[source] = &cachedInfo{info: info, modTime: stat.ModTime(), size: stat.Size()}
+	infoCacheMu.Unlock()
+
+	return info, nil
+}
+
+// VariantPath returns the path of the variant of the image at path with the
+// given width, like `image-480w.jpg` for `image.jpg`. JPEGs and PNGs keep
+// their format and GIFs become PNGs. It works equally well on URL paths.
+func VariantPath(path string, width int) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + strconv.Itoa(width) + "w" +
+		variantExts[strings.ToLower(ext)]
+}
+
+// WriteVariants writes variants of the image at source at each of widths
+// (see Info.VariantWidths) into targetDir under the names given by
+// VariantPath, and returns their paths.
+func WriteVariants(source, targetDir string, widths []int, opts *Options) ([]string, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	data, hash, err := readImage(source)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decoded only if a variant isn't already cached.
+	var img image.Image
+
+	// Variants are produced from widest to narrowest, each resized from the
+	// last one produced rather than the original. Resizing is proportional
+	// to the size of the image being resized, so this is much faster for
+	// large images, and the ratios involved are small enough that the
+	// difference in quality isn't noticeable.
+	order := make([]int, len(widths))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return widths[order[i]] > widths[order[j]] })
+
+	targets := make([]string, len(widths))
+	for _, i := range order {
+		width := widths[i]
+		targets[i] = VariantPath(filepath.Join(targetDir, filepath.Base(source)), width)
+
+		var cachePath string
+		if opts.CacheDir != "" {
+			cachePath = filepath.Join(opts.CacheDir,
+				hash+"-"+strconv.Itoa(width)+"w"+filepath.Ext(targets[i]))
+
+			if variant, err := os.ReadFile(cachePath); err == nil {
+				if err := mfile.WriteFileAtomic(targets[i], variant, 0o644); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		if img == nil {
+			img, _, err = image.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, xerrors.Errorf("error decoding image '%s': %w", source, err)
+			}
+		}
+
+		img = resize(img, width)
+
+		variant, err := encodeVariant(img, filepath.Ext(targets[i]), opts)
+		if err != nil {
+			return nil, xerrors.Errorf("error encoding variant of '%s': %w", source, err)
+		}
+
+		if cachePath != "" {
+			if err := mfile.WriteFileAtomic(cachePath, variant, 0o600); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := mfile.WriteFileAtomic(targets[i], variant, 0o644); err != nil {
+			return nil, err
+		}
+	}
+
+	return targets, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Number of characters of a hex-encoded SHA-256 hash used to key cached
+// variants.
+const hashLength = 16
+
+// The EXIF tag containing an image's orientation.
+const exifTagOrientation = 0x0112
+
+// Maps the extensions of supported images to the extensions of their
+// variants.
+var variantExts = map[string]string{
+	".gif":  ".png",
+	".jpeg": ".jpeg",
+	".jpg":  ".jpg",
+	".png":  ".png",
+}
+
+var (
+	infoCache   = make(map[string]*cachedInfo)
+	infoCacheMu sync.Mutex
+
+	placeholderCache   = make(map[string]*cachedPlaceholder)
+	placeholderCacheMu sync.Mutex
+)
+
+// An Info along with the state of the file that it was read from.
+type cachedInfo struct {
+	info    *Info
+	modTime time.Time
+	size    int64
+}
+
+// A placeholder's data URI along with the state of the file that it was
+// produced from.
+type cachedPlaceholder struct {
+	uri     string
+	modTime time.Time
+	size    int64
+}
+
+func encodeVariant(img image.Image, ext string, opts *Options) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch ext {
+	case ".jpeg", ".jpg":
+		quality := opts.JPEGQuality
+		if quality == 0 {
+			quality = DefaultJPEGQuality
+		}
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, xerrors.Errorf("error encoding JPEG: %w", err)
+		}
+
+	default:
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, xerrors.Errorf("error encoding PNG: %w", err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Reads the orientation from a JPEG's EXIF data, returning 1 (the default) if
+// there isn't one. Only the segments before the image data are read.
+func jpegOrientation(r io.Reader) int {
+	br := bufio.NewReader(r)
+
+	var marker [2]byte
+	if _, err := io.ReadFull(br, marker[:]); err != nil || marker != [2]byte{0xff, 0xd8} {
+		return 1
+	}
+
+	for {
+		if _, err := io.ReadFull(br, marker[:]); err != nil || marker[0] != 0xff {
+			return 1
+		}
+
+		// Start of scan, after which there are no more metadata segments.
+		if marker[1] == 0xda {
+			return 1
+		}
+
+		var length uint16
+		if err := binary.Read(br, binary.BigEndian, &length); err != nil || length < 2 {
+			return 1
+		}
+
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(br, segment); err != nil {
+			return 1
+		}
+
+		// APP1, which holds EXIF data.
+		if marker[1] == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+	}
+}
+
+// Reads the orientation from EXIF data (starting with its TIFF header),
+// returning 1 (the default) if there isn't one.
+func exifOrientation(data []byte) int {
+	if len(data) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(data[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(data[4:8]))
+	if offset < 0 || offset+2 > len(data) {
+		return 1
+	}
+
+	numEntries := int(order.Uint16(data[offset : offset+2]))
+	for i := range numEntries {
+		entry := offset + 2 + i*12
+		if entry+12 > len(data) {
+			return 1
+		}
+
+		if order.Uint16(data[entry:entry+2]) == exifTagOrientation {
+			return int(order.Uint16(data[entry+8 : entry+10]))
+		}
+	}
+
+	return 1
+}
+
+// Reads the image at source along with the hash of its contents that cached
+// variants and placeholders are keyed by.
+func readImage(source string) ([]byte, string, error) {
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, "", xerrors.Errorf("error reading image: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return data, hex.EncodeToString(sum[:])[0:hashLength], nil
+}
+
+func readInfo(source string) (*Info, error) {
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, xerrors.Errorf("error opening image: %w", err)
+	}
+	defer f.Close()
+
+	config, format, err := image.DecodeConfig(f)
+	if errors.Is(err, image.ErrFormat) {
+		return &Info{Unrecognized: true}, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error decoding image '%s': %w", source, err)
+	}
+
+	info := &Info{Height: config.Height, Width: config.Width}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, xerrors.Errorf("error seeking image: %w", err)
+	}
+
+	switch format {
+	case "gif":
+		all, err := gif.DecodeAll(f)
+		if err != nil {
+			return nil, xerrors.Errorf("error decoding image '%s': %w", source, err)
+		}
+		info.Animated = len(all.Image) > 1
+
+	case "jpeg":
+		info.Rotated = jpegOrientation(f) > 1
+	}
+
+	return info, nil
+}
+
+// Produces the data URI of the placeholder for the image at source (see
+// Placeholder). Images that don't get a placeholder are cached as an empty
+// file so that they aren't decoded again to find that out.
+func readPlaceholder(source string, opts *Options) (string, error) {
+	info, err := ReadInfo(source)
+	if err != nil {
+		return "", err
+	}
+	if info.Animated || info.Rotated || info.Unrecognized {
+		return "", nil
+	}
+
+	data, hash, err := readImage(source)
+	if err != nil {
+		return "", err
+	}
+
+	var cachePath string
+	if opts.CacheDir != "" {
+		cachePath = filepath.Join(opts.CacheDir, hash+"-placeholder.png")
+
+		if placeholder, err := os.ReadFile(cachePath); err == nil {
+			return placeholderURI(placeholder), nil
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", xerrors.Errorf("error decoding image '%s': %w", source, err)
+	}
+
+	var placeholder []byte
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		placeholder, err = encodeVariant(resize(img, PlaceholderWidth), ".png", opts)
+		if err != nil {
+			return "", xerrors.Errorf("error encoding placeholder of '%s': %w", source, err)
+		}
+	}
+
+	if cachePath != "" {
+		if err := mfile.WriteFileAtomic(cachePath, placeholder, 0o600); err != nil {
+			return "", err
+		}
+	}
+
+	return placeholderURI(placeholder), nil
+}
+
+// Gets a data URI for an encoded placeholder, or an empty string if there
+// isn't one.
+func placeholderURI(placeholder []byte) string {
+	if len(placeholder) < 1 {
+		return ""
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(placeholder)
+}
+
+// Scales img to the given width, keeping its aspect ratio. Images more than
+// twice as wide as width are first halved with a cheap filter until they
+// aren't, because Catmull-Rom gets slow as the scale factor grows, and
+// halving loses nothing that it would have kept anyway.
+func resize(img image.Image, width int) image.Image {
+	for img.Bounds().Dx() >= width*2 {
+		img = scale(img, img.Bounds().Dx()/2, draw.ApproxBiLinear)
+	}
+
+	return scale(img, width, draw.CatmullRom)
+}
+
+func scale(img image.Image, width int, scaler draw.Scaler) image.Image {
+	bounds := img.Bounds()
+	height := max(1, (bounds.Dy()*width+bounds.Dx()/2)/bounds.Dx())
+
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	scaler.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+	return scaled
+}
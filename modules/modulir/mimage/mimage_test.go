@@ -0,0 +1,235 @@
+package mimage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestInfoVariantWidths(t *testing.T) {
+	widths := []int{480, 960, 1440}
+
+	assert.Equal(t, []int{480, 960}, (&Info{Width: 1000}).VariantWidths(widths))
+	assert.Equal(t, []int{480, 960, 1440}, (&Info{Width: 2000}).VariantWidths(widths))
+	assert.Nil(t, (&Info{Width: 480}).VariantWidths(widths))
+
+	assert.Nil(t, (&Info{Animated: true, Width: 2000}).VariantWidths(widths))
+	assert.Nil(t, (&Info{Rotated: true, Width: 2000}).VariantWidths(widths))
+	assert.Nil(t, (&Info{Unrecognized: true}).VariantWidths(widths))
+}
+
+func TestIsSupported(t *testing.T) {
+	assert.True(t, IsSupported("a.jpg"))
+	assert.True(t, IsSupported("a.JPEG"))
+	assert.True(t, IsSupported("a.png"))
+	assert.True(t, IsSupported("a.gif"))
+	assert.False(t, IsSupported("a.svg"))
+	assert.False(t, IsSupported("a"))
+}
+
+func TestVariantPath(t *testing.T) {
+	assert.Equal(t, "images/a-480w.jpg", VariantPath("images/a.jpg", 480))
+	assert.Equal(t, "/content/images/a.b-960w.png", VariantPath("/content/images/a.b.png", 960))
+	assert.Equal(t, "a-480w.png", VariantPath("a.gif", 480))
+}
+
+func TestPlaceholder(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	opts := &Options{CacheDir: cacheDir}
+
+	source := filepath.Join(dir, "a.png")
+	writePNG(t, source, 200, 100)
+
+	uri, err := Placeholder(source, opts)
+	assert.NoError(t, err)
+
+	data, ok := strings.CutPrefix(uri, "data:image/png;base64,")
+	assert.True(t, ok)
+
+	placeholder, err := base64.StdEncoding.DecodeString(data)
+	assert.NoError(t, err)
+
+	config, err := png.DecodeConfig(bytes.NewReader(placeholder))
+	assert.NoError(t, err)
+	assert.Equal(t, PlaceholderWidth, config.Width)
+	assert.Equal(t, PlaceholderWidth/2, config.Height)
+
+	cached, err := filepath.Glob(filepath.Join(cacheDir, "*-placeholder.png"))
+	assert.NoError(t, err)
+	assert.Len(t, cached, 1)
+
+	// Images with transparency don't get a placeholder.
+	transparent := filepath.Join(dir, "b.png")
+	f, err := os.Create(transparent)
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 40, 30))))
+	assert.NoError(t, f.Close())
+
+	uri, err = Placeholder(transparent, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "", uri)
+
+	// Nor do animated ones.
+	animated := filepath.Join(dir, "d.gif")
+	f, err = os.Create(animated)
+	assert.NoError(t, err)
+	frame := image.NewPaletted(image.Rect(0, 0, 40, 30), color.Palette{color.Black, color.White})
+	assert.NoError(t, gif.EncodeAll(f, &gif.GIF{
+		Image: []*image.Paletted{frame, frame},
+		Delay: []int{10, 10},
+	}))
+	assert.NoError(t, f.Close())
+
+	uri, err = Placeholder(animated, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "", uri)
+
+	// Nor do images that can't be decoded.
+	unrecognized := filepath.Join(dir, "c.jpg")
+	assert.NoError(t, os.WriteFile(unrecognized, []byte("not an image"), 0o600))
+
+	uri, err = Placeholder(unrecognized, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "", uri)
+}
+
+func TestReadInfo(t *testing.T) {
+	dir := t.TempDir()
+
+	source := filepath.Join(dir, "a.png")
+	writePNG(t, source, 200, 100)
+
+	info, err := ReadInfo(source)
+	assert.NoError(t, err)
+	assert.Equal(t, &Info{Height: 100, Width: 200}, info)
+
+	unrecognized := filepath.Join(dir, "b.jpg")
+	assert.NoError(t, os.WriteFile(unrecognized, []byte("not an image"), 0o600))
+
+	info, err = ReadInfo(unrecognized)
+	assert.NoError(t, err)
+	assert.Equal(t, &Info{Unrecognized: true}, info)
+
+	_, err = ReadInfo(filepath.Join(dir, "missing.png"))
+	assert.Error(t, err)
+}
+
+func TestWriteVariants(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	targetDir := t.TempDir()
+
+	source := filepath.Join(dir, "a.png")
+	writePNG(t, source, 200, 100)
+
+	opts := &Options{CacheDir: cacheDir}
+
+	targets, err := WriteVariants(source, targetDir, []int{50, 100}, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(targetDir, "a-50w.png"),
+		filepath.Join(targetDir, "a-100w.png"),
+	}, targets)
+
+	for i, width := range []int{50, 100} {
+		info, err := ReadInfo(targets[i])
+		assert.NoError(t, err)
+		assert.Equal(t, width, info.Width)
+		assert.Equal(t, width/2, info.Height)
+	}
+
+	cached, err := filepath.Glob(filepath.Join(cacheDir, "*"))
+	assert.NoError(t, err)
+	assert.Len(t, cached, 2)
+
+	// Variants come out of the cache even if the target has been cleared.
+	assert.NoError(t, os.RemoveAll(targetDir))
+	assert.NoError(t, os.MkdirAll(targetDir, 0o755))
+
+	targets, err = WriteVariants(source, targetDir, []int{50, 100}, opts)
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(targets[0])
+	assert.NoError(t, err)
+
+	cachedPaths, err := filepath.Glob(filepath.Join(cacheDir, "*-50w.png"))
+	assert.NoError(t, err)
+	assert.Len(t, cachedPaths, 1)
+
+	cachedData, err := os.ReadFile(cachedPaths[0])
+	assert.NoError(t, err)
+	assert.Equal(t, cachedData, data)
+}
+
+func TestExifOrientation(t *testing.T) {
+	assert.Equal(t, 6, exifOrientation(exifData(binary.LittleEndian, 6)))
+	assert.Equal(t, 3, exifOrientation(exifData(binary.BigEndian, 3)))
+
+	// Malformed data falls back to the default.
+	assert.Equal(t, 1, exifOrientation(nil))
+	assert.Equal(t, 1, exifOrientation([]byte("XX\x00\x2a\x00\x00\x00\x08")))
+	assert.Equal(t, 1, exifOrientation(exifData(binary.LittleEndian, 6)[0:12]))
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	segment := append([]byte("Exif\x00\x00"), exifData(binary.BigEndian, 8)...)
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, 0xd8, 0xff, 0xe1})
+	assert.NoError(t, binary.Write(&buf, binary.BigEndian, uint16(len(segment)+2)))
+	buf.Write(segment)
+	buf.Write([]byte{0xff, 0xda})
+
+	assert.Equal(t, 8, jpegOrientation(&buf))
+	assert.Equal(t, 1, jpegOrientation(bytes.NewReader([]byte{0xff, 0xd8, 0xff, 0xda})))
+	assert.Equal(t, 1, jpegOrientation(bytes.NewReader([]byte("not a jpeg"))))
+}
+
+//
+// Private
+//
+
+// Produces EXIF data (starting with its TIFF header) containing only an
+// orientation.
+func exifData(order binary.ByteOrder, orientation int) []byte {
+	data := make([]byte, 8+2+12)
+	if order == binary.LittleEndian {
+		copy(data, "II")
+	} else {
+		copy(data, "MM")
+	}
+	order.PutUint16(data[2:4], 42)
+	order.PutUint32(data[4:8], 8)
+	order.PutUint16(data[8:10], 1)
+	order.PutUint16(data[10:12], exifTagOrientation)
+	order.PutUint16(data[12:14], 3) // SHORT
+	order.PutUint32(data[14:18], 1)
+	order.PutUint16(data[18:20], uint16(orientation))
+	return data
+}
+
+func writePNG(t *testing.T, path string, width, height int) {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	assert.NoError(t, png.Encode(f, img))
+}
@@ -0,0 +1,112 @@
+// Package mjsonfeed provides a minimal set of types for producing feeds that
+// conform to JSON Feed 1.1 (https://www.jsonfeed.org/version/1.1/).
+package mjsonfeed
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Public
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Version is the URL identifying the version of the format that feeds
+// produced by this package conform to.
+const Version = "https://jsonfeed.org/version/1.1"
+
+// Feed represents a JSON feed.
+type Feed struct {
+	// Authors are the authors of the feed's items.
+	Authors []*Author `json:"authors,omitempty"`
+
+	// Description is a short description of the feed.
+	Description string `json:"description,omitempty"`
+
+	// FeedURL is the URL of the feed itself.
+	FeedURL string `json:"feed_url,omitempty"`
+
+	// HomePageURL is the URL of the website that the feed describes.
+	HomePageURL string `json:"home_page_url,omitempty"`
+
+	// Icon is the URL of an image for the feed.
+	Icon string `json:"icon,omitempty"`
+
+	// Items are the feed's items, usually ordered most recent first.
+	Items []*Item `json:"items"`
+
+	// Language is the primary language of the feed.
+	Language string `json:"language,omitempty"`
+
+	// Title is a human-readable title for the feed.
+	Title string `json:"title"`
+
+	// Version is the version of the format. It's set automatically by
+	// Encode.
+	Version string `json:"version"`
+}
+
+// Encode encodes the feed as JSON to the given writer. Indent may be empty to
+// produce compact output.
+func (f *Feed) Encode(w io.Writer, indent string) error {
+	f.Version = Version
+
+	// Items is required by the spec, so make sure it's never encoded as null.
+	if f.Items == nil {
+		f.Items = []*Item{}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", indent)
+	if err := encoder.Encode(f); err != nil {
+		return xerrors.Errorf("error encoding feed: %w", err)
+	}
+
+	return nil
+}
+
+// Author represents the author of a feed or item.
+type Author struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+// Item represents a single item in a JSON feed.
+type Item struct {
+	// ContentHTML is the full HTML content of the item.
+	ContentHTML string `json:"content_html,omitempty"`
+
+	// DatePublished is the time that the item was first published.
+	DatePublished time.Time `json:"date_published"`
+
+	// DateModified is the last time the item was modified.
+	DateModified time.Time `json:"date_modified"`
+
+	// ID is a permanent, unique identifier for the item.
+	ID string `json:"id"`
+
+	// Image is the URL of the main image for the item.
+	Image string `json:"image,omitempty"`
+
+	// Summary is a plain text summary of the item.
+	Summary string `json:"summary,omitempty"`
+
+	// Tags are tags that the item has been categorized with.
+	Tags []string `json:"tags,omitempty"`
+
+	// Title is a human-readable title for the item.
+	Title string `json:"title,omitempty"`
+
+	// URL is the URL of the HTML version of the item.
+	URL string `json:"url,omitempty"`
+}
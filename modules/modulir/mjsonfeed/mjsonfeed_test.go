@@ -0,0 +1,43 @@
+package mjsonfeed
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestFeedEncode(t *testing.T) {
+	publishedAt := time.Date(2024, 5, 2, 18, 33, 36, 0, time.UTC)
+
+	feed := &Feed{
+		Title:       "Articles",
+		HomePageURL: "https://example.com",
+		Items: []*Item{
+			{
+				ContentHTML:   "<p>Hello & welcome</p>",
+				DateModified:  publishedAt,
+				DatePublished: publishedAt,
+				ID:            "https://example.com/euclid",
+				Tags:          []string{"Science"},
+				Title:         "Euclid",
+				URL:           "https://example.com/euclid",
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, feed.Encode(&buf, ""))
+
+	assert.Equal(t, `{"home_page_url":"https://example.com","items":[{"content_html":"<p>Hello & welcome</p>",`+
+		`"date_published":"2024-05-02T18:33:36Z","date_modified":"2024-05-02T18:33:36Z","id":"https://example.com/euclid",`+
+		`"tags":["Science"],"title":"Euclid","url":"https://example.com/euclid"}],"title":"Articles",`+
+		`"version":"https://jsonfeed.org/version/1.1"}`+"\n", buf.String())
+}
+
+func TestFeedEncodeEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, (&Feed{Title: "Empty"}).Encode(&buf, ""))
+	assert.Equal(t, `{"items":[],"title":"Empty","version":"https://jsonfeed.org/version/1.1"}`+"\n", buf.String())
+}
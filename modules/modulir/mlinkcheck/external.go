@@ -0,0 +1,462 @@
+package mlinkcheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"coolstercodes/modules/modulir/mfile"
+	"coolstercodes/modules/modulir/mmarkdownext"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Public
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// ExternalLink is an external link found in the built site along with the
+// result of checking it.
+type ExternalLink struct {
+	// Files are the paths of the HTML files containing the link, relative to
+	// the target directory and sorted.
+	Files []string
+
+	// Result is the result of checking the link, or nil if it wasn't checked
+	// (in offline mode, because there was no result for it in the cache).
+	Result *ExternalResult
+
+	// URL is the link's URL.
+	URL string
+}
+
+// ExternalOptions are options for CheckExternal.
+type ExternalOptions struct {
+	// CachePath is the path to a file where results are stored between runs
+	// so that links checked recently don't need to be checked again.
+	//
+	// Defaults to not caching results if left unset.
+	CachePath string
+
+	// CacheTTL is how long a successful result is reused for before its link
+	// is checked again. Broken links are always checked again (unless
+	// Offline is set) in case they were only temporarily unavailable.
+	//
+	// Defaults to 7 days.
+	CacheTTL time.Duration
+
+	// Client is the HTTP client used to check links.
+	//
+	// Defaults to a client with a 15 second timeout.
+	Client *http.Client
+
+	// Concurrency is the maximum number of requests in flight at once.
+	//
+	// Defaults to 8.
+	Concurrency int
+
+	// HostInterval is the minimum time between the start of requests to the
+	// same host so that no host is hammered with requests.
+	//
+	// Defaults to 1 second.
+	HostInterval time.Duration
+
+	// Offline skips network access entirely and reports results from the
+	// cache regardless of their age. Links without a cached result aren't
+	// checked.
+	Offline bool
+}
+
+// ExternalResult is the result of checking an external link.
+type ExternalResult struct {
+	// CheckedAt is when the link was checked.
+	CheckedAt time.Time `json:"checked_at"`
+
+	// Error is a description of the error that occurred when requesting the
+	// link, if one did.
+	Error string `json:"error,omitempty"`
+
+	// StatusCode is the final status code returned for the link after
+	// following any redirects, or zero if the request errored.
+	StatusCode int `json:"status_code,omitempty"`
+}
+
+// OK returns true if the link was reachable.
+func (r *ExternalResult) OK() bool {
+	return r.Error == "" && r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// String returns a human-readable description of the result.
+func (r *ExternalResult) String() string {
+	if r.Error != "" {
+		return r.Error
+	}
+	return fmt.Sprintf("status %d", r.StatusCode)
+}
+
+// CheckExternal finds every absolute `http(s)` link in the content of HTML
+// files in targetDir (the same ones that mmarkdownext opens in new tabs) and
+// checks that each is reachable, returning all of them sorted by URL.
+//
+// Links are requested with HEAD, falling back to GET for servers that don't
+// support it. Results are cached to CachePath so that reruns are cheap, and
+// the cache is saved even if checking is interrupted by ctx.
+func CheckExternal(ctx context.Context, targetDir string, opts *ExternalOptions) ([]*ExternalLink, error) {
+	opts = initExternalOptionsDefaults(opts)
+
+	links, err := extractExternalLinks(targetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := loadExternalCache(opts.CachePath)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	var toCheck []*ExternalLink
+	for _, link := range links {
+		result, ok := cache.Results[link.URL]
+		if !ok {
+			if !opts.Offline {
+				toCheck = append(toCheck, link)
+			}
+			continue
+		}
+
+		if opts.Offline || (result.OK() && now.Sub(result.CheckedAt) < opts.CacheTTL) {
+			link.Result = result
+			continue
+		}
+
+		toCheck = append(toCheck, link)
+	}
+
+	newExternalChecker(opts).checkAll(ctx, toCheck)
+
+	if !opts.Offline {
+		// Only keep results for links that are still in the site so that the
+		// cache doesn't grow forever. Links that didn't get checked because
+		// ctx was done keep their previous result.
+		results := make(map[string]*ExternalResult, len(links))
+		for _, link := range links {
+			if link.Result != nil {
+				results[link.URL] = link.Result
+			} else if result, ok := cache.Results[link.URL]; ok {
+				results[link.URL] = result
+			}
+		}
+		cache.Results = results
+
+		if err := cache.save(opts.CachePath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("error checking external links: %w", err)
+	}
+
+	return links, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Identifies the checker to servers, some of which reject requests from Go's
+// default user agent.
+const externalUserAgent = "Mozilla/5.0 (compatible; coolstercodes-linkcheck)"
+
+// externalCache is the format of the file at CachePath.
+type externalCache struct {
+	// Results maps URLs to the results of checking them.
+	Results map[string]*ExternalResult `json:"results"`
+}
+
+// Checks external links, limiting the number of requests in flight and the
+// rate of requests to each host.
+type externalChecker struct {
+	client *http.Client
+
+	// hostInterval is the minimum time between the start of requests to
+	// the same host.
+	hostInterval time.Duration
+
+	// hostNext maps hosts to the earliest time that the next request to
+	// them may start.
+	hostNext map[string]time.Time
+
+	// hostNextMu synchronizes access to hostNext.
+	hostNextMu sync.Mutex
+
+	// semaphore has a slot for each request allowed in flight.
+	semaphore chan struct{}
+}
+
+func newExternalChecker(opts *ExternalOptions) *externalChecker {
+	return &externalChecker{
+		client:       opts.Client,
+		hostInterval: opts.HostInterval,
+		hostNext:     make(map[string]time.Time),
+		semaphore:    make(chan struct{}, opts.Concurrency),
+	}
+}
+
+// Checks links concurrently, setting their results. Links that weren't checked
+// because ctx was done are left without one.
+func (c *externalChecker) checkAll(ctx context.Context, links []*ExternalLink) {
+	var wg sync.WaitGroup
+	for _, l := range links {
+		link := l
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result, err := c.check(ctx, link.URL)
+			if err != nil {
+				return
+			}
+			link.Result = result
+		}()
+	}
+	wg.Wait()
+}
+
+// Requests a single link and produces a result. Only returns an error if ctx
+// was done before the link could be checked.
+func (c *externalChecker) check(ctx context.Context, link string) (*ExternalResult, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return &ExternalResult{CheckedAt: time.Now(), Error: "invalid URL"}, nil
+	}
+
+	statusCode, err := c.request(ctx, u, http.MethodHead)
+
+	// Plenty of servers don't support HEAD, or respond differently to it, so
+	// try again with GET before declaring the link broken.
+	if err == nil && (statusCode < 200 || statusCode >= 300) {
+		statusCode, err = c.request(ctx, u, http.MethodGet)
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	result := &ExternalResult{CheckedAt: time.Now(), StatusCode: statusCode}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result, nil
+}
+
+// Makes a request to u once its host's rate limit and the concurrency limit
+// allow it, and returns the response's status code.
+func (c *externalChecker) request(ctx context.Context, u *url.URL, method string) (int, error) {
+	if err := c.waitForHost(ctx, u.Host); err != nil {
+		return 0, err
+	}
+
+	select {
+	case c.semaphore <- struct{}{}:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	defer func() { <-c.semaphore }()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return 0, xerrors.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("User-Agent", externalUserAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, xerrors.Errorf("error requesting link: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Drain a little of the body so that the connection can be reused, but
+	// don't bother with large pages.
+	_, _ = io.CopyN(io.Discard, resp.Body, 64*1024)
+
+	return resp.StatusCode, nil
+}
+
+// Waits until a request to host is allowed, or ctx is done. Waiting doesn't
+// take up one of the slots for requests in flight so that requests to other
+// hosts can proceed in the meantime.
+func (c *externalChecker) waitForHost(ctx context.Context, host string) error {
+	c.hostNextMu.Lock()
+	now := time.Now()
+	start := c.hostNext[host]
+	if start.Before(now) {
+		start = now
+	}
+	c.hostNext[host] = start.Add(c.hostInterval)
+	c.hostNextMu.Unlock()
+
+	timer := time.NewTimer(time.Until(start))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Finds external links in the HTML files in targetDir, sorted by URL.
+func extractExternalLinks(targetDir string) ([]*ExternalLink, error) {
+	targetDir = filepath.Clean(targetDir)
+	filesByURL := make(map[string]map[string]struct{})
+
+	err := filepath.WalkDir(targetDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".html") {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return xerrors.Errorf("error reading file: %w", err)
+		}
+
+		relPath, err := filepath.Rel(targetDir, path)
+		if err != nil {
+			return xerrors.Errorf("error getting relative path: %w", err)
+		}
+
+		for _, link := range mmarkdownext.ExtractAbsoluteLinks(string(data)) {
+			files, ok := filesByURL[link]
+			if !ok {
+				files = make(map[string]struct{})
+				filesByURL[link] = files
+			}
+			files[filepath.ToSlash(relPath)] = struct{}{}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("error walking target directory: %w", err)
+	}
+
+	links := make([]*ExternalLink, 0, len(filesByURL))
+	for link, files := range filesByURL {
+		externalLink := &ExternalLink{URL: link}
+		for file := range files {
+			externalLink.Files = append(externalLink.Files, file)
+		}
+		sort.Strings(externalLink.Files)
+		links = append(links, externalLink)
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].URL < links[j].URL
+	})
+
+	return links, nil
+}
+
+func initExternalOptionsDefaults(opts *ExternalOptions) *ExternalOptions {
+	if opts == nil {
+		opts = &ExternalOptions{}
+	}
+
+	// Copy so that the caller's options aren't modified.
+	o := *opts
+
+	if o.CacheTTL <= 0 {
+		o.CacheTTL = 7 * 24 * time.Hour
+	}
+
+	if o.Client == nil {
+		o.Client = &http.Client{Timeout: 15 * time.Second}
+	}
+
+	if o.Concurrency <= 0 {
+		o.Concurrency = 8
+	}
+
+	if o.HostInterval <= 0 {
+		o.HostInterval = time.Second
+	}
+
+	return &o
+}
+
+// Loads the cache from path. A cache that doesn't exist yet is empty.
+func loadExternalCache(path string) (*externalCache, error) {
+	cache := &externalCache{Results: make(map[string]*ExternalResult)}
+
+	if path == "" {
+		return cache, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error reading external link cache: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cache); err != nil {
+		return nil, xerrors.Errorf("error decoding external link cache: %w", err)
+	}
+
+	if cache.Results == nil {
+		cache.Results = make(map[string]*ExternalResult)
+	}
+
+	return cache, nil
+}
+
+// Saves the cache to path atomically so that an interrupted save doesn't
+// corrupt it.
+func (c *externalCache) save(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("error encoding external link cache: %w", err)
+	}
+
+	if err := mfile.WriteFileAtomic(path, data, 0o600); err != nil {
+		return xerrors.Errorf("error writing external link cache: %w", err)
+	}
+
+	return nil
+}
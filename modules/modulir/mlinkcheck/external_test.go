@@ -0,0 +1,150 @@
+package mlinkcheck
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCheckExternal(t *testing.T) {
+	var requestsMu sync.Mutex
+	requests := make(map[string][]string)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(http.ResponseWriter, *http.Request) {})
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestsMu.Lock()
+		requests[r.URL.Path] = append(requests[r.URL.Path], r.Method)
+		requestsMu.Unlock()
+
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(path.Join(dir, "tags"), 0o755))
+	assert.NoError(t, os.WriteFile(path.Join(dir, "index.html"), []byte(
+		`<a href="`+server.URL+`/ok" target="_blank">OK</a>`+
+			`<a href="`+server.URL+`/missing" target="_blank">Missing</a>`+
+			`<a href="/relative">Relative</a>`,
+	), 0o600))
+	assert.NoError(t, os.WriteFile(path.Join(dir, "tags", "index.html"), []byte(
+		`<a href="`+server.URL+`/ok" target="_blank">OK</a>`+
+			`<a href="`+server.URL+`/no-head" target="_blank">No HEAD</a>`+
+			`<a href="`+server.URL+`/moved" target="_blank">Moved</a>`,
+	), 0o600))
+
+	opts := &ExternalOptions{
+		CachePath:    path.Join(dir, "cache.json"),
+		HostInterval: time.Millisecond,
+	}
+
+	links, err := CheckExternal(context.Background(), dir, opts)
+	assert.NoError(t, err)
+	assert.Len(t, links, 4)
+
+	linksByPath := make(map[string]*ExternalLink)
+	for _, link := range links {
+		linksByPath[link.URL[len(server.URL):]] = link
+	}
+
+	assert.Equal(t, []string{"index.html", "tags/index.html"}, linksByPath["/ok"].Files)
+	assert.True(t, linksByPath["/ok"].Result.OK())
+	assert.False(t, linksByPath["/missing"].Result.OK())
+	assert.Equal(t, "status 404", linksByPath["/missing"].Result.String())
+	assert.True(t, linksByPath["/no-head"].Result.OK())
+	assert.True(t, linksByPath["/moved"].Result.OK())
+
+	assert.Equal(t, []string{http.MethodHead, http.MethodGet}, requests["/no-head"])
+
+	// Results are reused from the cache, except for broken links, which are
+	// checked again.
+	requests = make(map[string][]string)
+
+	_, err = CheckExternal(context.Background(), dir, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"/missing": {http.MethodHead, http.MethodGet},
+	}, requests)
+
+	// Offline mode doesn't make any requests at all, and reports cached
+	// results regardless of their age.
+	server.Close()
+
+	offlineOpts := *opts
+	offlineOpts.CacheTTL = time.Nanosecond
+	offlineOpts.Offline = true
+
+	links, err = CheckExternal(context.Background(), dir, &offlineOpts)
+	assert.NoError(t, err)
+	for _, link := range links {
+		assert.NotNil(t, link.Result)
+	}
+
+	// A link that's not in the cache isn't checked when offline.
+	assert.NoError(t, os.WriteFile(path.Join(dir, "new.html"), []byte(
+		`<a href="https://example.com/new" target="_blank">New</a>`,
+	), 0o600))
+
+	links, err = CheckExternal(context.Background(), dir, &offlineOpts)
+	assert.NoError(t, err)
+	assert.Len(t, links, 5)
+	assert.Equal(t, "https://example.com/new", links[4].URL)
+	assert.Nil(t, links[4].Result)
+
+	// Check the cache's format.
+	data, err := os.ReadFile(opts.CachePath)
+	assert.NoError(t, err)
+
+	var cache externalCache
+	assert.NoError(t, json.Unmarshal(data, &cache))
+	assert.Len(t, cache.Results, 4)
+	assert.False(t, cache.Results[server.URL+"/ok"].CheckedAt.IsZero())
+}
+
+func TestCheckExternalHostInterval(t *testing.T) {
+	var startsMu sync.Mutex
+	var starts []time.Time
+
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		startsMu.Lock()
+		starts = append(starts, time.Now())
+		startsMu.Unlock()
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(path.Join(dir, "index.html"), []byte(
+		`<a href="`+server.URL+`/1">1</a>`+
+			`<a href="`+server.URL+`/2">2</a>`+
+			`<a href="`+server.URL+`/3">3</a>`,
+	), 0o600))
+
+	interval := 50 * time.Millisecond
+	_, err := CheckExternal(context.Background(), dir, &ExternalOptions{
+		Concurrency:  3,
+		HostInterval: interval,
+	})
+	assert.NoError(t, err)
+
+	assert.Len(t, starts, 3)
+	assert.GreaterOrEqual(t, starts[2].Sub(starts[0]), 2*interval-5*time.Millisecond)
+}
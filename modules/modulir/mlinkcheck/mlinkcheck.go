@@ -0,0 +1,299 @@
+package mlinkcheck
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/xerrors"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Public
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// BrokenLink is a link in a built HTML file that doesn't resolve to anything
+// in the target directory.
+type BrokenLink struct {
+	// File is the path of the HTML file containing the link, relative to the
+	// target directory.
+	File string
+
+	// Link is the link as it appears in the file.
+	Link string
+
+	// Reason is a short description of why the link is broken.
+	Reason string
+}
+
+// String returns a human-readable description of the broken link.
+func (l *BrokenLink) String() string {
+	return fmt.Sprintf("%s: %s (%s)", l.File, l.Link, l.Reason)
+}
+
+// Options are options for Check.
+type Options struct {
+	// IgnorePaths are root-relative paths (e.g. `/websocket.js`) that are
+	// assumed to exist even though they're not in the target directory, like
+	// those served dynamically.
+	IgnorePaths []string
+}
+
+// Check parses every HTML file in targetDir and returns any internal links in
+// them that are broken, sorted by file and then link. Links are taken from
+// `href`, `src`, and `srcset` attributes.
+//
+// Relative and root-relative links are resolved against the files in
+// targetDir using the same rules as Modulir's development server (and most
+// static hosts): a path that doesn't exist resolves to the same path with
+// `.html` appended, and a directory resolves to its `index.html`. A fragment
+// in a link to an HTML file must match an element's `id` (or an anchor's
+// `name`) in that file. Links with a scheme or host are external and aren't
+// checked.
+func Check(targetDir string, opts *Options) ([]*BrokenLink, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	// Keep paths from the walk and from resolving links comparable.
+	targetDir = filepath.Clean(targetDir)
+
+	ignorePaths := make(map[string]struct{}, len(opts.IgnorePaths))
+	for _, p := range opts.IgnorePaths {
+		ignorePaths[p] = struct{}{}
+	}
+
+	var files []string
+	err := filepath.WalkDir(targetDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".html") {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("error walking target directory: %w", err)
+	}
+
+	// Parse every file up front so that the anchors of any file can be looked
+	// up when resolving fragments.
+	pages := make(map[string]*page, len(files))
+	for _, file := range files {
+		p, err := parsePage(file)
+		if err != nil {
+			return nil, err
+		}
+		pages[file] = p
+	}
+
+	var broken []*BrokenLink
+	for _, file := range files {
+		relFile, err := filepath.Rel(targetDir, file)
+		if err != nil {
+			return nil, xerrors.Errorf("error getting relative path: %w", err)
+		}
+		relFile = filepath.ToSlash(relFile)
+
+		for _, link := range pages[file].links {
+			reason := checkLink(targetDir, file, relFile, link, pages, ignorePaths)
+			if reason != "" {
+				broken = append(broken, &BrokenLink{File: relFile, Link: link, Reason: reason})
+			}
+		}
+	}
+
+	sort.SliceStable(broken, func(i, j int) bool {
+		if broken[i].File != broken[j].File {
+			return broken[i].File < broken[j].File
+		}
+		return broken[i].Link < broken[j].Link
+	})
+
+	return broken, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Attributes that contain a single link.
+var linkAttrs = map[string]struct{}{
+	"href": {},
+	"src":  {},
+}
+
+// page is the information extracted from an HTML file that's needed to check
+// links.
+type page struct {
+	// anchors are the IDs of elements (and names of anchors) in the page,
+	// which are valid targets for fragments.
+	anchors map[string]struct{}
+
+	// links are the links in the page, in order of appearance and
+	// deduplicated.
+	links []string
+}
+
+// Checks a single link in file (which is relFile relative to targetDir) and
+// returns why it's broken, or an empty string if it isn't.
+func checkLink(targetDir, file, relFile, link string,
+	pages map[string]*page, ignorePaths map[string]struct{},
+) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "invalid URL"
+	}
+
+	// External, or something like `mailto:` or `javascript:`.
+	if u.Scheme != "" || u.Host != "" {
+		return ""
+	}
+
+	target := file
+	if u.Path != "" {
+		urlPath := u.Path
+		if !strings.HasPrefix(urlPath, "/") {
+			urlPath = path.Join("/", path.Dir(relFile), urlPath)
+		}
+
+		if _, ok := ignorePaths[urlPath]; ok {
+			return ""
+		}
+
+		var reason string
+		target, reason = resolvePath(targetDir, urlPath)
+		if reason != "" {
+			return reason
+		}
+	}
+
+	if u.Fragment == "" {
+		return ""
+	}
+
+	// Only HTML files have anchors to check.
+	targetPage, ok := pages[target]
+	if !ok {
+		return ""
+	}
+
+	// Browsers scroll to the top of the page for `#top` if there's no
+	// element with that ID.
+	if u.Fragment == "top" {
+		return ""
+	}
+
+	if _, ok := targetPage.anchors[u.Fragment]; !ok {
+		return "anchor not found"
+	}
+
+	return ""
+}
+
+// Parses the HTML file at file, extracting its anchors and links.
+func parsePage(file string) (*page, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, xerrors.Errorf("error opening file: %w", err)
+	}
+	defer f.Close()
+
+	p := &page{anchors: make(map[string]struct{})}
+	seenLinks := make(map[string]struct{})
+
+	addLink := func(link string) {
+		link = strings.TrimSpace(link)
+		if link == "" {
+			return
+		}
+
+		if _, ok := seenLinks[link]; ok {
+			return
+		}
+		seenLinks[link] = struct{}{}
+		p.links = append(p.links, link)
+	}
+
+	tokenizer := html.NewTokenizer(f)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != nil && !errors.Is(err, io.EOF) {
+				return nil, xerrors.Errorf("error parsing '%s': %w", file, err)
+			}
+			return p, nil
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+
+			for _, attr := range token.Attr {
+				switch {
+				case attr.Key == "id" || (attr.Key == "name" && token.Data == "a"):
+					p.anchors[attr.Val] = struct{}{}
+
+				case attr.Key == "srcset":
+					for _, candidate := range strings.Split(attr.Val, ",") {
+						if fields := strings.Fields(candidate); len(fields) > 0 {
+							addLink(fields[0])
+						}
+					}
+
+				default:
+					if _, ok := linkAttrs[attr.Key]; ok {
+						addLink(attr.Val)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Resolves a root-relative URL path to a file in targetDir in the same way
+// that Modulir's development server does. Returns the file's path, or a
+// reason that it couldn't be resolved.
+func resolvePath(targetDir, urlPath string) (string, string) {
+	fullPath := filepath.Join(targetDir, filepath.FromSlash(urlPath))
+
+	info, err := os.Stat(fullPath)
+	if err == nil {
+		if !info.IsDir() {
+			return fullPath, ""
+		}
+
+		index := filepath.Join(fullPath, "index.html")
+		if _, err := os.Stat(index); err != nil {
+			return "", "directory without index.html"
+		}
+		return index, ""
+	}
+
+	if _, err := os.Stat(fullPath + ".html"); err == nil {
+		return fullPath + ".html", ""
+	}
+
+	return "", "not found"
+}
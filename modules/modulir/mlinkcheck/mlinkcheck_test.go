@@ -0,0 +1,75 @@
+package mlinkcheck
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile := func(name, data string) {
+		assert.NoError(t, os.MkdirAll(path.Dir(path.Join(dir, name)), 0o755))
+		assert.NoError(t, os.WriteFile(path.Join(dir, name), []byte(data), 0o600))
+	}
+
+	writeFile("index.html", `<html><body>
+<a href="/euclid">Pretty URL</a>
+<a href="/euclid.html#the-elements">Anchor</a>
+<a href="/euclid#missing">Missing anchor</a>
+<a href="/tags/">Directory</a>
+<a href="/tags">Directory without slash</a>
+<a href="/content/images/euclid/Euclid.png">Image</a>
+<a href="/empty/">Directory without index</a>
+<a href="/missing">Missing</a>
+<a href="#top">Top</a>
+<a href="#intro">Same page</a>
+<a href="https://example.com/missing">External</a>
+<a href="mailto:hello@example.com">Email</a>
+<a href="javascript:void(0)">JavaScript</a>
+<script src="/websocket.js"></script>
+<p id="intro">Intro</p>
+</body></html>`)
+
+	writeFile("euclid.html", `<html><body>
+<h2 id="the-elements"><a href="#the-elements">The elements</a></h2>
+<a name="old-anchor"></a>
+<img src="content/images/euclid/Euclid.png" srcset="content/images/euclid/Euclid.png 1x, content/images/euclid/Euclid@2x.png 2x">
+<a href="#old-anchor">Named anchor</a>
+</body></html>`)
+
+	writeFile("tags/index.html", `<html><body>
+<a href="../euclid">Relative</a>
+<a href="euclid.html">Relative missing</a>
+</body></html>`)
+
+	writeFile("content/images/euclid/Euclid.png", "png")
+	assert.NoError(t, os.MkdirAll(path.Join(dir, "empty"), 0o755))
+
+	broken, err := Check(dir, &Options{IgnorePaths: []string{"/websocket.js"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []*BrokenLink{
+		{File: "euclid.html", Link: "content/images/euclid/Euclid@2x.png", Reason: "not found"},
+		{File: "index.html", Link: "/empty/", Reason: "directory without index.html"},
+		{File: "index.html", Link: "/euclid#missing", Reason: "anchor not found"},
+		{File: "index.html", Link: "/missing", Reason: "not found"},
+		{File: "tags/index.html", Link: "euclid.html", Reason: "not found"},
+	}, broken)
+
+	assert.Equal(t, "index.html: /missing (not found)", broken[3].String())
+}
+
+func TestCheckNoOptions(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(path.Join(dir, "index.html"),
+		[]byte(`<script src="/websocket.js"></script>`), 0o600))
+
+	broken, err := Check(dir, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*BrokenLink{
+		{File: "index.html", Link: "/websocket.js", Reason: "not found"},
+	}, broken)
+}
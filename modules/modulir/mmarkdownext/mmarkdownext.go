@@ -5,12 +5,22 @@ package mmarkdownext
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"html"
+	"image"
+	"net/url"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"text/template"
 
+	// Registered so that the dimensions of images in these formats can be read.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
 	"golang.org/x/xerrors"
 	"gopkg.in/russross/blackfriday.v2"
 )
@@ -36,6 +46,49 @@ type RenderOptions struct {
 
 	// ImgDir is the path to the images
 	ImgDir string
+
+	// ImgSourceDir is the directory containing the sources of the images
+	// served from ImgDir. If set, images are given their dimensions so that
+	// browsers can lay out a page before they've loaded, and it's an error
+	// to reference one that doesn't exist.
+	ImgSourceDir string
+
+	// ImagePlaceholder returns the URL (usually a data URI) of a placeholder
+	// for an image (given its URL, after ImgDir is applied), which is shown
+	// behind it while it loads. Images go without one if it's nil or returns
+	// an empty string.
+	ImagePlaceholder func(img string) (string, error)
+
+	// ImageSizes is the `sizes` attribute given to images that have
+	// variants, describing how wide they're displayed at various viewport
+	// widths.
+	ImageSizes string
+
+	// ImageVariants returns the versions of an image (given its URL, after
+	// ImgDir is applied) to offer in a `srcset`, including the original. Images
+	// are rendered with only a `src` if it's nil or returns none.
+	ImageVariants func(img string) ([]*ImageVariant, error)
+}
+
+// ImageVariant is a version of an image offered in a `srcset`.
+type ImageVariant struct {
+	// URL is the URL of the version.
+	URL string
+
+	// Width is the version's width in pixels.
+	Width int
+}
+
+// ExtractAbsoluteLinks returns the URLs of all absolute "http*" links in
+// rendered HTML, which are the same ones that rendering opens in new tabs. URLs
+// are unescaped and returned in order of appearance, including duplicates.
+func ExtractAbsoluteLinks(source string) []string {
+	matches := absoluteLinkRE.FindAllStringSubmatch(source, -1)
+	links := make([]string, len(matches))
+	for i, match := range matches {
+		links[i] = html.UnescapeString(match[1])
+	}
+	return links
 }
 
 // Render a Markdown string to HTML while applying all custom project-specific
@@ -128,22 +181,22 @@ var pdfRE = regexp.MustCompile(`(!\[\]\((.*).pdf\))(\n\*(.*)\*)?`)
 func transformPDFs(source string, opts *RenderOptions) (string, error) {
 	return pdfRE.ReplaceAllStringFunc(source, func(figure string) string {
 		matches := figureRE.FindStringSubmatch(figure)
-		if len(matches) != 5 {
+		if len(matches) != 8 {
 			return figure
 		}
 		// Grab the pdf (it's the same every time)
-		pdf := matches[2]
+		pdf := matches[3]
 		if opts.ImgDir != "" {
 			pdf = filepath.Join(opts.ImgDir, pdf)
 		}
 
 		// No caption option
-		if matches[3] == "" {
+		if matches[6] == "" {
 			return fmt.Sprintf(pdfHTMLNoCaption, pdf)
 		}
 
-		// Grab the caption (only if 3rd arg isn't empty)
-		caption := matches[4]
+		// Grab the caption (only if 6th arg isn't empty)
+		caption := matches[7]
 		return fmt.Sprintf(pdfHTMLCaption, pdf, caption)
 	}), nil
 }
@@ -151,7 +204,7 @@ func transformPDFs(source string, opts *RenderOptions) (string, error) {
 const figureHTMLCaption = `
 <figure class="text-center">
   <a data-fancybox="gallery" href="%s" data-caption="%s">
-    <img src="%s" />
+    <img %s />
   </a>
   <figcaption>%s</figcaption>
 </figure>
@@ -159,17 +212,22 @@ const figureHTMLCaption = `
 
 const figureHTMLNoCaption = `
 <a data-fancybox="gallery" href="%s">
-  <img src="%s" />
+  <img %s />
 </a>
 `
 
 // Let me break this regex down:
 /*
 	( - Starts first group
-		!\[\] - Matches the "![]"
-			Note: if we wanted to add alt text later, it would be !\[(.*)\]
-		\( - matches first paren
-		(.*) - matches everything until closing paren
+		!\[ - Matches the "!["
+		([^\]\n]*) - matches the alt text (if any) until the closing bracket
+		\]\( - matches the closing bracket and first paren
+		([^"\n]*) - matches everything until closing paren (or title)
+		( - Starts optional title group
+			\s+" - matches whitespace and the opening quote
+			([^"\n]*) - matches the title
+			" - matches the closing quote
+		)? - Ends optional title group
 		\) - matches closing paren
 	) - Ends first group
 	( - Starts second optional group
@@ -180,31 +238,144 @@ const figureHTMLNoCaption = `
 	) - Ends second optional group
 	? - Makes second group option (in case there is no caption given)
 */
-var figureRE = regexp.MustCompile(`(!\[\]\((.*)\))(\n\*(.*)\*)?`)
+var figureRE = regexp.MustCompile(`(!\[([^\]\n]*)\]\(([^"\n]*)(\s+"([^"\n]*)")?\))(\n\*(.*)\*)?`)
 
 func transformImages(source string, opts *RenderOptions) (string, error) {
-	return figureRE.ReplaceAllStringFunc(source, func(figure string) string {
+	var err error
+
+	source = figureRE.ReplaceAllStringFunc(source, func(figure string) string {
 		matches := figureRE.FindStringSubmatch(figure)
-		if len(matches) != 5 {
+		if len(matches) != 8 || err != nil {
 			return figure
 		}
 		// Grab the image (it's the same every time)
-		img := matches[2]
+		img := matches[3]
 		if opts.ImgDir != "" {
 			img = filepath.Join(opts.ImgDir, img)
 		}
 
+		// Alt text falls back to the caption because that's usually a fine
+		// description of the image, and every image should have one.
+		alt := matches[2]
+		title := matches[5]
+		caption := matches[7]
+		if alt == "" {
+			alt = caption
+		}
+
+		var imgAttrs string
+		imgAttrs, err = getImageAttrs(img, matches[3], alt, title, opts)
+		if err != nil {
+			return figure
+		}
+
 		// No caption option
-		if matches[3] == "" {
-			return fmt.Sprintf(figureHTMLNoCaption, img, img)
+		if matches[6] == "" {
+			return fmt.Sprintf(figureHTMLNoCaption, img, imgAttrs)
 		}
 
-		// Grab the caption (only if 3rd arg isn't empty)
-		caption := matches[4]
-		return fmt.Sprintf(figureHTMLCaption, img, caption, img, caption)
-	}), nil
+		// Grab the caption (only if 6th arg isn't empty)
+		caption = html.EscapeString(caption)
+		return fmt.Sprintf(figureHTMLCaption, img, caption, imgAttrs, caption)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return source, nil
+}
+
+// Gets the attributes of an `<img>` tag for the image at img (referenced as
+// ref in the source), which include a `srcset` and `sizes` if the image has
+// variants, its dimensions if its source is available, and a placeholder
+// background if it has one. Images are loaded lazily because most of them are
+// well below the fold.
+func getImageAttrs(img, ref, alt, title string, opts *RenderOptions) (string, error) {
+	attrs := fmt.Sprintf(`src="%s" alt="%s"`, img, html.EscapeString(alt))
+	if title != "" {
+		attrs += fmt.Sprintf(` title="%s"`, html.EscapeString(title))
+	}
+
+	if opts.ImageVariants != nil {
+		variants, err := opts.ImageVariants(img)
+		if err != nil {
+			return "", xerrors.Errorf("error getting variants of image '%s': %w", img, err)
+		}
+
+		if len(variants) > 0 {
+			candidates := make([]string, len(variants))
+			for i, variant := range variants {
+				candidates[i] = fmt.Sprintf("%s %dw", srcsetURLReplacer.Replace(variant.URL), variant.Width)
+			}
+
+			attrs += fmt.Sprintf(` srcset="%s"`, strings.Join(candidates, ", "))
+			if opts.ImageSizes != "" {
+				attrs += fmt.Sprintf(` sizes="%s"`, opts.ImageSizes)
+			}
+		}
+	}
+
+	if opts.ImgSourceDir != "" {
+		width, height, err := readImageDimensions(opts.ImgSourceDir, ref)
+		if err != nil {
+			return "", err
+		}
+
+		if width > 0 && height > 0 {
+			attrs += fmt.Sprintf(` width="%d" height="%d"`, width, height)
+		}
+	}
+
+	if opts.ImagePlaceholder != nil {
+		placeholder, err := opts.ImagePlaceholder(img)
+		if err != nil {
+			return "", xerrors.Errorf("error getting placeholder of image '%s': %w", img, err)
+		}
+
+		if placeholder != "" {
+			attrs += fmt.Sprintf(` style="background-image: url('%s'); background-size: cover"`,
+				html.EscapeString(placeholder))
+		}
+	}
+
+	return attrs + ` loading="lazy" decoding="async"`, nil
 }
 
+// Reads the dimensions of the image referenced as ref from the directory
+// dir. The reference is URL-escaped like any other link in Markdown. Images
+// in formats that can't be decoded (like SVG) have no dimensions rather than
+// being an error because browsers can display them just fine.
+func readImageDimensions(dir, ref string) (int, int, error) {
+	name, err := url.PathUnescape(ref)
+	if err != nil {
+		name = ref
+	}
+	source := filepath.Join(dir, name)
+
+	f, err := os.Open(source)
+	if os.IsNotExist(err) {
+		return 0, 0, xerrors.Errorf("image '%s' doesn't exist (referenced as '%s')", source, ref)
+	}
+	if err != nil {
+		return 0, 0, xerrors.Errorf("error opening image: %w", err)
+	}
+	defer f.Close()
+
+	config, _, err := image.DecodeConfig(f)
+	if errors.Is(err, image.ErrFormat) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, xerrors.Errorf("error decoding image '%s': %w", source, err)
+	}
+
+	return config.Width, config.Height, nil
+}
+
+// Escapes the characters that separate candidates in a `srcset`, which are
+// otherwise fine to leave unescaped in a URL.
+var srcsetURLReplacer = strings.NewReplacer(" ", "%20", ",", "%2C")
+
 const fileHTML = `
 <a href="%s" download">%s</a>
 `
@@ -372,7 +543,7 @@ func transformFootnotes(source string, _ *RenderOptions) (string, error) {
 }
 
 // This just always transforms any "http*" links to blank targets to open in new tabs.
-var absoluteLinkRE = regexp.MustCompile(`<a href="http[^"]+"`)
+var absoluteLinkRE = regexp.MustCompile(`<a href="(http[^"]+)"`)
 
 func transformLinksToTargetBlank(source string, _ *RenderOptions) (string, error) {
 	return absoluteLinkRE.ReplaceAllStringFunc(source, func(link string) string {
@@ -1,9 +1,14 @@
 package mmarkdownext
 
 import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
 	"testing"
 
 	assert "github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
 )
 
 func TestCollapseHTML(t *testing.T) {
@@ -28,7 +33,7 @@ func TestTransformImages(t *testing.T) {
 	assert.Equal(t, `
 <figure class="text-center">
   <a data-fancybox="gallery" href="/content/images/hey/img.png" data-caption="some puppies">
-    <img src="/content/images/hey/img.png" />
+    <img src="/content/images/hey/img.png" alt="some puppies" loading="lazy" decoding="async" />
   </a>
   <figcaption>some puppies</figcaption>
 </figure>
@@ -39,13 +44,155 @@ func TestTransformImages(t *testing.T) {
 
 	assert.Equal(t, `
 <a data-fancybox="gallery" href="/content/images/hey/img.png">
-  <img src="/content/images/hey/img.png" />
+  <img src="/content/images/hey/img.png" alt="" loading="lazy" decoding="async" />
 </a>
 `,
 		must(transformImages(`![](./img.png)`, &RenderOptions{ImgDir: "/content/images/hey"})),
 	)
 }
 
+func TestTransformImagesVariants(t *testing.T) {
+	opts := &RenderOptions{
+		ImgDir:     "/content/images/hey",
+		ImageSizes: "(min-width: 768px) 670px, 100vw",
+		ImageVariants: func(img string) ([]*ImageVariant, error) {
+			if img != "/content/images/hey/big img.png" {
+				return nil, nil
+			}
+
+			return []*ImageVariant{
+				{URL: "/content/images/hey/big img-480w.png", Width: 480},
+				{URL: "/content/images/hey/big img.png", Width: 1200},
+			}, nil
+		},
+	}
+
+	assert.Equal(t, `
+<a data-fancybox="gallery" href="/content/images/hey/big img.png">
+  <img src="/content/images/hey/big img.png" alt="" srcset="/content/images/hey/big%20img-480w.png 480w, /content/images/hey/big%20img.png 1200w" sizes="(min-width: 768px) 670px, 100vw" loading="lazy" decoding="async" />
+</a>
+`,
+		must(transformImages(`![](./big img.png)`, opts)),
+	)
+
+	// Images without variants only get a `src`.
+	assert.Equal(t, `
+<a data-fancybox="gallery" href="/content/images/hey/img.png">
+  <img src="/content/images/hey/img.png" alt="" loading="lazy" decoding="async" />
+</a>
+`,
+		must(transformImages(`![](./img.png)`, opts)),
+	)
+
+	opts.ImageVariants = func(string) ([]*ImageVariant, error) {
+		return nil, xerrors.New("bad image")
+	}
+
+	_, err := transformImages(`![](./img.png)`, opts)
+	assert.EqualError(t, err, "error getting variants of image '/content/images/hey/img.png': bad image")
+}
+
+func TestTransformImagesAltAndTitle(t *testing.T) {
+	opts := &RenderOptions{ImgDir: "/content/images/hey"}
+
+	assert.Equal(t, `
+<figure class="text-center">
+  <a data-fancybox="gallery" href="/content/images/hey/img.png" data-caption="Hitting the &#34;b==0&#34; base case">
+    <img src="/content/images/hey/img.png" alt="Euclid &amp; friends" title="A &lt;title&gt;" loading="lazy" decoding="async" />
+  </a>
+  <figcaption>Hitting the &#34;b==0&#34; base case</figcaption>
+</figure>
+`,
+		must(transformImages(`![Euclid & friends](./img.png "A <title>")
+*Hitting the "b==0" base case*`, opts)),
+	)
+
+	// Paths may contain parentheses, and titles are optional.
+	assert.Equal(t, `
+<a data-fancybox="gallery" href="/content/images/hey/img%20(1).png">
+  <img src="/content/images/hey/img%20(1).png" alt="Some puppies" loading="lazy" decoding="async" />
+</a>
+`,
+		must(transformImages(`![Some puppies](./img%20(1).png)`, opts)),
+	)
+}
+
+func TestTransformImagesPlaceholder(t *testing.T) {
+	opts := &RenderOptions{
+		ImgDir: "/content/images/hey",
+		ImagePlaceholder: func(img string) (string, error) {
+			if img != "/content/images/hey/img.png" {
+				return "", nil
+			}
+
+			return "data:image/png;base64,iVBORw0KGgo=", nil
+		},
+	}
+
+	assert.Equal(t, `
+<a data-fancybox="gallery" href="/content/images/hey/img.png">
+  <img src="/content/images/hey/img.png" alt="" style="background-image: url('data:image/png;base64,iVBORw0KGgo='); background-size: cover" loading="lazy" decoding="async" />
+</a>
+`,
+		must(transformImages(`![](./img.png)`, opts)),
+	)
+
+	// Images without a placeholder go without a background.
+	assert.Equal(t, `
+<a data-fancybox="gallery" href="/content/images/hey/other.png">
+  <img src="/content/images/hey/other.png" alt="" loading="lazy" decoding="async" />
+</a>
+`,
+		must(transformImages(`![](./other.png)`, opts)),
+	)
+
+	opts.ImagePlaceholder = func(string) (string, error) {
+		return "", xerrors.New("bad image")
+	}
+
+	_, err := transformImages(`![](./img.png)`, opts)
+	assert.EqualError(t, err, "error getting placeholder of image '/content/images/hey/img.png': bad image")
+}
+
+func TestTransformImagesDimensions(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := os.Create(filepath.Join(dir, "big img.png"))
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(f, image.NewGray(image.Rect(0, 0, 40, 30))))
+	assert.NoError(t, f.Close())
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "avif.jpg"), []byte("not a JPEG"), 0o600))
+
+	opts := &RenderOptions{ImgDir: "/content/images/hey", ImgSourceDir: dir}
+
+	assert.Equal(t, `
+<a data-fancybox="gallery" href="/content/images/hey/big%20img.png">
+  <img src="/content/images/hey/big%20img.png" alt="" width="40" height="30" loading="lazy" decoding="async" />
+</a>
+`,
+		must(transformImages(`![](./big%20img.png)`, opts)),
+	)
+
+	// Images in formats that can't be decoded go without dimensions.
+	assert.Equal(t, `
+<a data-fancybox="gallery" href="/content/images/hey/avif.jpg">
+  <img src="/content/images/hey/avif.jpg" alt="" loading="lazy" decoding="async" />
+</a>
+`,
+		must(transformImages(`![](./avif.jpg)`, opts)),
+	)
+
+	_, err = transformImages(`![](./missing.png)`, opts)
+	assert.EqualError(t, err, "image '"+filepath.Join(dir, "missing.png")+"' doesn't exist (referenced as './missing.png')")
+
+	// Damaged images are an error too rather than being silently rendered
+	// without dimensions.
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "truncated.png"), []byte("\x89PNG\r\n\x1a\n"), 0o600))
+	_, err = transformImages(`![](./truncated.png)`, opts)
+	assert.ErrorContains(t, err, "error decoding image '"+filepath.Join(dir, "truncated.png")+"'")
+}
+
 func TestTransformFootnotes(t *testing.T) {
 	assert.Equal(t, `
 <p>This is a reference <sup id="footnote-1-source"><a href="#footnote-1">1</a></sup>
@@ -92,6 +239,19 @@ Intro here.
 	)
 }
 
+func TestExtractAbsoluteLinks(t *testing.T) {
+	assert.Equal(t,
+		[]string{"https://example.com/?a=1&b=2", "http://example.com"},
+		ExtractAbsoluteLinks(
+			`<a href="https://example.com/?a=1&amp;b=2" target="_blank">Example</a>`+
+				`<a href="/relative">Relative link</a>`+
+				`<a href="http://example.com">Example</a>`,
+		),
+	)
+
+	assert.Empty(t, ExtractAbsoluteLinks(`<p>No links</p>`))
+}
+
 func TestTransformLinksTargetBlank(t *testing.T) {
 	assert.Equal(t,
 		`<a href="https://example.com" target="_blank">Example</a>`+
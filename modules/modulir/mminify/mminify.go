@@ -0,0 +1,275 @@
+// Package mminify provides a conservative HTML minifier for rendered pages.
+package mminify
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/xerrors"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Public
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// HTML minifies the HTML read from r and writes it to w.
+//
+// Minification is conservative so that it never changes how a page renders:
+//
+//   - Comments are removed, except for conditional comments.
+//   - Runs of whitespace in text are collapsed to a single space, and
+//     whitespace next to block-level elements (where browsers ignore it
+//     anyway) is removed entirely.
+//   - Whitespace within tags is collapsed, but attribute values are left
+//     exactly as they are.
+//   - The contents of `<pre>`, `<code>`, `<textarea>`, `<script>`, and
+//     `<style>` elements are left exactly as they are.
+//
+// Text and attribute values are copied as they appear in the source, so
+// character references aren't changed either.
+func HTML(w io.Writer, r io.Reader) error {
+	m := &minifier{lastBlock: true, w: w}
+
+	tokenizer := html.NewTokenizer(r)
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			if err := tokenizer.Err(); !errors.Is(err, io.EOF) {
+				return xerrors.Errorf("error parsing HTML: %w", err)
+			}
+			return m.err
+		}
+
+		m.handleToken(tokenizer, tokenType)
+		if m.err != nil {
+			return m.err
+		}
+	}
+}
+
+// NewHTMLWriter returns a writer that minifies HTML written to it (see HTML)
+// and writes the result to w when it's closed. Nothing is written to w before
+// Close is called.
+func NewHTMLWriter(w io.Writer) io.WriteCloser {
+	return &htmlWriter{w: w}
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Elements that are laid out as blocks (or not rendered at all), so that
+// whitespace next to their tags isn't significant.
+var blockElements = map[string]struct{}{
+	"address": {}, "article": {}, "aside": {}, "base": {}, "blockquote": {},
+	"body": {}, "br": {}, "dd": {}, "details": {}, "dialog": {}, "div": {},
+	"dl": {}, "dt": {}, "fieldset": {}, "figcaption": {}, "figure": {},
+	"footer": {}, "form": {}, "h1": {}, "h2": {}, "h3": {}, "h4": {}, "h5": {},
+	"h6": {}, "head": {}, "header": {}, "hgroup": {}, "hr": {}, "html": {},
+	"li": {}, "link": {}, "main": {}, "meta": {}, "nav": {}, "noscript": {},
+	"ol": {}, "option": {}, "p": {}, "pre": {}, "script": {}, "section": {},
+	"style": {}, "summary": {}, "table": {}, "tbody": {}, "td": {},
+	"tfoot": {}, "th": {}, "thead": {}, "title": {}, "tr": {}, "ul": {},
+}
+
+// Elements whose contents are copied exactly. The tokenizer returns the
+// contents of `<script>`, `<style>`, and `<textarea>` as a single text token,
+// but `<pre>` and `<code>` can contain other elements, so they're tracked by
+// depth.
+var preserveElements = map[string]struct{}{
+	"code":     {},
+	"pre":      {},
+	"script":   {},
+	"style":    {},
+	"textarea": {},
+}
+
+// Buffers everything written to it and minifies it on Close.
+type htmlWriter struct {
+	buf bytes.Buffer
+	w   io.Writer
+}
+
+func (w *htmlWriter) Close() error {
+	return HTML(w.w, &w.buf)
+}
+
+func (w *htmlWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// State for a single run of HTML.
+type minifier struct {
+	// err is the first error that occurred while writing.
+	err error
+
+	// lastBlock is whether the last thing written was a block-level tag (or
+	// nothing at all), in which case whitespace that follows it can be
+	// dropped.
+	lastBlock bool
+
+	// pendingSpace is whether whitespace was seen after the last thing
+	// written. Whether it's significant depends on what comes next.
+	pendingSpace bool
+
+	// preserveDepth is the number of open elements whose contents are being
+	// copied exactly.
+	preserveDepth int
+
+	w io.Writer
+}
+
+func (m *minifier) handleToken(tokenizer *html.Tokenizer, tokenType html.TokenType) {
+	switch tokenType {
+	case html.CommentToken:
+		raw := tokenizer.Raw()
+
+		// Conditional comments are used to target old versions of Internet
+		// Explorer, so they're meaningful.
+		if bytes.HasPrefix(raw, []byte("<!--[if")) || bytes.HasPrefix(raw, []byte("<![endif]")) {
+			m.writeSpace(false)
+			m.write(raw)
+		}
+
+	case html.DoctypeToken:
+		m.pendingSpace = false
+		m.write(tokenizer.Raw())
+		m.lastBlock = true
+
+	case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+		raw := tokenizer.Raw()
+		name, _ := tokenizer.TagName()
+
+		_, block := blockElements[string(name)]
+		m.writeSpace(block)
+		m.write([]byte(collapseTag(string(raw))))
+		m.lastBlock = block
+
+		if _, ok := preserveElements[string(name)]; ok {
+			switch tokenType {
+			// A self-closing slash is ignored on elements that aren't void,
+			// so these are start tags as far as browsers are concerned.
+			case html.StartTagToken, html.SelfClosingTagToken:
+				m.preserveDepth++
+			case html.EndTagToken:
+				if m.preserveDepth > 0 {
+					m.preserveDepth--
+				}
+			}
+		}
+
+	case html.TextToken:
+		raw := tokenizer.Raw()
+
+		if m.preserveDepth > 0 {
+			m.writeSpace(false)
+			m.write(raw)
+			m.lastBlock = false
+			return
+		}
+
+		m.handleText(string(raw))
+	}
+}
+
+// Writes text with its whitespace collapsed. Leading and trailing whitespace
+// is deferred until it's known whether the text is next to a block.
+func (m *minifier) handleText(text string) {
+	fields := strings.FieldsFunc(text, isSpace)
+	if len(fields) < 1 {
+		if text != "" {
+			m.pendingSpace = true
+		}
+		return
+	}
+
+	if isSpace(rune(text[0])) {
+		m.pendingSpace = true
+	}
+
+	m.writeSpace(false)
+	m.write([]byte(strings.Join(fields, " ")))
+	m.lastBlock = false
+
+	m.pendingSpace = isSpace(rune(text[len(text)-1]))
+}
+
+func (m *minifier) write(p []byte) {
+	if m.err != nil {
+		return
+	}
+	_, m.err = m.w.Write(p)
+}
+
+// Writes a single space for pending whitespace, unless it's next to a block
+// (either the last thing written, or what's about to be if nextBlock is true)
+// where it's insignificant.
+func (m *minifier) writeSpace(nextBlock bool) {
+	if m.pendingSpace && !m.lastBlock && !nextBlock {
+		m.write([]byte(" "))
+	}
+	m.pendingSpace = false
+}
+
+// Collapses whitespace in a raw tag outside of attribute values, and removes
+// it before the tag's closing bracket. Whitespace before `/>` is kept because
+// removing it would make the slash part of an unquoted attribute value.
+func collapseTag(raw string) string {
+	var sb strings.Builder
+	sb.Grow(len(raw))
+
+	var quote rune
+	var space bool
+
+	for _, r := range raw {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+
+		case isSpace(r):
+			space = true
+			continue
+
+		case r == '"' || r == '\'':
+			quote = r
+		}
+
+		if space {
+			if r != '>' {
+				sb.WriteByte(' ')
+			}
+			space = false
+		}
+
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
+
+// Whether r is whitespace as defined by HTML, which unlike unicode.IsSpace
+// doesn't include non-breaking spaces.
+func isSpace(r rune) bool {
+	switch r {
+	case ' ', '\t', '\n', '\f', '\r':
+		return true
+	}
+	return false
+}
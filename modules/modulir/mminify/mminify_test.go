@@ -0,0 +1,114 @@
+package mminify
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestHTML(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			"Comments",
+			"<p>Hello <!-- a comment -->world</p>",
+			"<p>Hello world</p>",
+		},
+		{
+			"ConditionalComments",
+			"<head>\n<!--[if lt IE 9]><script src=\"shiv.js\"></script><![endif]-->\n</head>",
+			"<head><!--[if lt IE 9]><script src=\"shiv.js\"></script><![endif]--></head>",
+		},
+		{
+			"Doctype",
+			"<!DOCTYPE html>\n<html>\n  <head>\n    <title>  Title  </title>\n  </head>\n</html>\n",
+			"<!DOCTYPE html><html><head><title>Title</title></head></html>",
+		},
+		{
+			"BlockWhitespace",
+			"<div>\n  <p>\n    One\n    two\n  </p>\n  <ul>\n    <li>Three</li>\n  </ul>\n</div>",
+			"<div><p>One two</p><ul><li>Three</li></ul></div>",
+		},
+		{
+			"InlineWhitespace",
+			"<p>A <em>b</em>\n  <a href=\"/c\">c</a>  d</p>",
+			"<p>A <em>b</em> <a href=\"/c\">c</a> d</p>",
+		},
+		{
+			"InlineWhitespaceInsideElement",
+			"<p>A<em> b </em>c</p>",
+			"<p>A<em> b </em>c</p>",
+		},
+		{
+			"Entities",
+			"<p>Fish &amp;  chips&nbsp; &lt;3</p>",
+			"<p>Fish &amp; chips&nbsp; &lt;3</p>",
+		},
+		{
+			"Pre",
+			"<pre>\n  line one\n\n  <span class=\"k\">line</span>  two\n</pre>",
+			"<pre>\n  line one\n\n  <span class=\"k\">line</span>  two\n</pre>",
+		},
+		{
+			"Code",
+			"<p>Run <code>a  &&\n  b</code> now</p>",
+			"<p>Run <code>a  &&\n  b</code> now</p>",
+		},
+		{
+			"Textarea",
+			"<form>\n<textarea>\n  keep   this\n</textarea>\n</form>",
+			"<form><textarea>\n  keep   this\n</textarea></form>",
+		},
+		{
+			"Script",
+			"<script>\n  // a comment\n  var a = \"<!-- b -->\";\n</script>\n<p>After</p>",
+			"<script>\n  // a comment\n  var a = \"<!-- b -->\";\n</script><p>After</p>",
+		},
+		{
+			"Style",
+			"<style>\n  p  { color: red; }\n</style>",
+			"<style>\n  p  { color: red; }\n</style>",
+		},
+		{
+			"Attributes",
+			"<a\n  href=\"/a  b\"\n  title='c  d'  >e</a>",
+			"<a href=\"/a  b\" title='c  d'>e</a>",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			assert.NoError(t, HTML(&buf, strings.NewReader(tc.in)))
+			assert.Equal(t, tc.out, buf.String())
+		})
+	}
+}
+
+func TestNewHTMLWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewHTMLWriter(&buf)
+
+	_, err := w.Write([]byte("<p>\n  Hello\n"))
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("  world\n</p>\n"))
+	assert.NoError(t, err)
+
+	// Nothing is written until the writer is closed.
+	assert.Equal(t, "", buf.String())
+
+	assert.NoError(t, w.Close())
+	assert.Equal(t, "<p>Hello world</p>", buf.String())
+}
+
+func TestCollapseTag(t *testing.T) {
+	assert.Equal(t, `<a href="/">`, collapseTag(`<a   href="/"  >`))
+	assert.Equal(t, `<img src="a b.png" alt='c  d' />`, collapseTag("<img\n\tsrc=\"a b.png\"\n\talt='c  d' />"))
+	assert.Equal(t, `<a href=foo />`, collapseTag(`<a href=foo  />`))
+	assert.Equal(t, `</div>`, collapseTag("</div\n>"))
+}
@@ -26,12 +26,76 @@ import (
 
 // Config contains configuration.
 type Config struct {
+	// BuildReportPath is a path to which a JSON build report (see
+	// BuildReport) is written after every build loop, replacing any report
+	// from the previous loop. Use "-" to write reports to stdout instead, one
+	// per line.
+	//
+	// Defaults to not writing a report if left unset.
+	BuildReportPath string
+
+	// CacheKey is an arbitrary string that identifies the kind of build being
+	// run (e.g. a combination of environment settings that affect output). It
+	// should also include a version or hash of the program's code so that
+	// changes to it invalidate the cache. A persistent cache produced with a
+	// different key is discarded.
+	//
+	// Defaults to empty.
+	CacheKey string
+
+	// CachePath is the path to a file where the build cache (modification
+	// times of sources and values registered with Context.StoreCached) is
+	// persisted between processes so that builds can be incremental across
+	// restarts. It's best placed outside of TargetDir so that it's not
+	// deployed with the site. A cache is only used for the target directory
+	// that it was produced for, and a full build is forced if any outputs
+	// that it recorded have gone missing from it (say because it was
+	// cleaned).
+	//
+	// Defaults to not persisting the cache if left unset.
+	CachePath string
+
+	// ChangeDetection is the strategy that Context.Changed uses to decide
+	// whether a source file has changed. See the ChangeDetection constants.
+	//
+	// Defaults to ChangeDetectionModTime.
+	ChangeDetection ChangeDetection
+
+	// CompressMinSize is the size in bytes below which files aren't worth
+	// compressing with CompressOutputs.
+	//
+	// Defaults to 1024.
+	CompressMinSize int64
+
+	// CompressOutputs causes gzip and brotli compressed variants of text
+	// files in TargetDir (HTML, CSS, JavaScript, JSON, etc.) to be written
+	// alongside them as `.gz` and `.br` files after every successful build.
+	// Only files that changed since they were last compressed are compressed
+	// again. The development server serves the variants to clients that
+	// accept them like most static hosts do.
+	//
+	// Defaults to false.
+	CompressOutputs bool
+
 	// Concurrency is the number of concurrent workers to run during the build
 	// step.
 	//
 	// Defaults to 10.
 	Concurrency int
 
+	// FailFast causes the first job to error during a build to cancel all
+	// other jobs in it (see Pool.FailFast).
+	//
+	// Defaults to false.
+	FailFast bool
+
+	// JobTimeout is the maximum amount of time that a job may run for before
+	// it's failed and its context cancelled. Jobs may override it with
+	// Job.Timeout.
+	//
+	// Defaults to no timeout if left unset.
+	JobTimeout time.Duration
+
 	// Log specifies a logger to use.
 	//
 	// Defaults to an instance of Logger running at informational level.
@@ -59,6 +123,14 @@ type Config struct {
 	// Defaults to "./public".
 	TargetDir string
 
+	// TracePath is a path to which a trace of the build's timeline is written
+	// after every build loop, replacing any trace from the previous loop. It
+	// shows how jobs were laid out across workers in each round, and can be
+	// loaded in Perfetto (ui.perfetto.dev) or Chrome's about:tracing.
+	//
+	// Defaults to not writing a trace if left unset.
+	TracePath string
+
 	// Websocket indicates that Modulir should be started in development
 	// mode with a websocket that provides features like live reload.
 	//
@@ -66,6 +138,33 @@ type Config struct {
 	Websocket bool
 }
 
+// ChangeDetection is a strategy for detecting whether a source file has
+// changed since it was last seen.
+type ChangeDetection string
+
+const (
+	// ChangeDetectionHash considers a file changed if a hash of its contents
+	// has changed. Modification times are ignored entirely, which makes it
+	// the most accurate strategy, but also the most expensive because every
+	// file needs to be read (files are hashed at most once per round).
+	ChangeDetectionHash ChangeDetection = "hash"
+
+	// ChangeDetectionModTime considers a file changed if its modification
+	// time is newer than when it was last seen. It's fast, but prone to false
+	// positives wherever modification times aren't preserved (e.g. a fresh
+	// `git checkout` or a restored CI cache), and false negatives where files
+	// are replaced with older ones (e.g. `cp -p`).
+	ChangeDetectionModTime ChangeDetection = "mtime"
+
+	// ChangeDetectionModTimeThenHash considers a file unchanged if its
+	// modification time and size are identical to when it was last seen.
+	// Otherwise, it falls back to comparing hashes of its contents. It's
+	// nearly as fast as ChangeDetectionModTime in the common case, but
+	// tolerant of modification times that change without the contents
+	// changing.
+	ChangeDetectionModTimeThenHash ChangeDetection = "mtime_then_hash"
+)
+
 // Build is one of the main entry points to the program. Call this to build
 // only one time.
 func Build(config *Config, f func(*Context) []error) {
@@ -153,8 +252,16 @@ func build(c *Context, f func(*Context) []error,
 	// filesystem and makes jobs much faster to run.
 	var lastChangedSources map[string]struct{}
 
+	// Whether the current loop was triggered by a scheduled rebuild (see
+	// Context.RebuildAt) rather than by the watcher. The watcher is only
+	// waiting to hear that a rebuild is done if it was the one to trigger it.
+	var scheduled bool
+
+	c.loadPersistentCache()
+
 	for {
 		c.Log.Debugf("Start loop")
+		buildCtx := c.startBuildContext()
 		c.ResetBuild()
 		c.StartRound()
 
@@ -168,10 +275,17 @@ func build(c *Context, f func(*Context) []error,
 
 		// Do one wait round as the build loop might not have waited on its
 		// last phase, but only bother if it looks like any jobs were enqueued.
-		if len(c.Pool.Jobs) > 0 {
+		if c.Pool.numJobs() > 0 {
 			lastRoundErrors = c.Wait()
 		}
 
+		// Compress outputs in a final round once everything else is done so
+		// that all of the files they'd be compressed from are in place.
+		if c.CompressOutputs && len(errors) < 1 && len(lastRoundErrors) < 1 &&
+			buildCtx.Err() == nil {
+			lastRoundErrors = c.compressOutputs()
+		}
+
 		// Context's Wait restarts the pool, so wait on that one more time to
 		// shut it back down.
 		c.Pool.Wait()
@@ -200,16 +314,81 @@ func build(c *Context, f func(*Context) []error,
 			len(c.Stats.JobsExecuted), c.Stats.NumJobs, c.Stats.NumRounds, len(c.Stats.JobsErrored),
 		)
 
+		if c.BuildReportPath != "" {
+			if err := c.writeBuildReport(newBuildReport(c, errors, buildDuration)); err != nil {
+				c.Log.Errorf("Error writing build report: %v", err)
+			}
+		}
+
+		if c.TracePath != "" {
+			if err := c.writeTrace(newTrace(c, buildDuration)); err != nil {
+				c.Log.Errorf("Error writing trace: %v", err)
+			}
+		}
+
+		// A build that was cancelled (e.g. because new changes came in) didn't
+		// get to finish its work, so make sure that the next build picks up
+		// where it left off. File states seen during the build are discarded
+		// so that their changes are detected again, and the next build is
+		// at least as broad as this one.
+		cancelled := !success && buildCtx.Err() != nil
+		var cancelledForced bool
+		var cancelledQuickPaths map[string]struct{}
+		if cancelled {
+			c.Log.Infof("Build cancelled: %v", context.Cause(buildCtx))
+			c.fileModTimeCache.discard()
+			cancelledForced = c.Forced
+			cancelledQuickPaths = c.QuickPaths
+		}
+
+		// Only prune outputs and persist the cache after a successful build.
+		// A failed build may not have enqueued all its jobs, so owners would
+		// look like they'd been removed, and sources of failed jobs shouldn't
+		// be recorded as being up to date.
+		if success && len(errors) < 1 {
+			c.pruneOutputs()
+
+			if err := c.savePersistentCache(); err != nil {
+				c.Log.Errorf("Error saving persistent cache: %v", err)
+			}
+		} else {
+			c.outputManifest.abandon()
+		}
+
+		// Produced before QuickPaths is reset below because it's used to
+		// determine whether clients can hot swap stylesheets.
+		buildEvent := newBuildEvent(c, errors)
+
+		c.Forced = false
 		c.QuickPaths = nil
 
-		buildComplete.Broadcast()
+		// Let clients know how the build went, unless it was cancelled, in
+		// which case another build is about to start and they'll hear about
+		// that one instead.
+		if !cancelled {
+			c.setLastBuildEvent(buildEvent)
+			buildComplete.Broadcast()
+		}
 
 		if c.FirstRun {
 			c.FirstRun = false
-		} else {
+		} else if !scheduled {
 			rebuildDone <- struct{}{}
 		}
 
+		// Scheduled rebuilds are only relevant when looping. A nil channel
+		// blocks forever, so the select below ignores it when nothing's
+		// scheduled.
+		var scheduledRebuild <-chan time.Time
+		var scheduledRebuildTimer *time.Timer
+		if c.Watcher != nil {
+			if next, ok := c.nextScheduledRebuild(); ok {
+				c.Log.Debugf("Next scheduled rebuild at %v", next)
+				scheduledRebuildTimer = time.NewTimer(time.Until(next))
+				scheduledRebuild = scheduledRebuildTimer.C
+			}
+		}
+
 		select {
 		case <-finish:
 			c.Log.Infof("Build loop detected finish signal; stopping")
@@ -218,6 +397,38 @@ func build(c *Context, f func(*Context) []error,
 		case lastChangedSources = <-rebuild:
 			c.Log.Infof("Build loop detected change on %v; rebuilding",
 				mapKeys(lastChangedSources))
+			scheduled = false
+
+		case <-scheduledRebuild:
+			c.Log.Infof("Build loop reached scheduled rebuild time; rebuilding")
+			c.removeElapsedScheduledRebuilds(time.Now())
+
+			// We don't know which sources are affected by the passage of
+			// time, so rebuild everything.
+			c.Forced = true
+			lastChangedSources = nil
+			scheduled = true
+		}
+
+		if scheduledRebuildTimer != nil {
+			scheduledRebuildTimer.Stop()
+		}
+
+		if cancelled {
+			c.Forced = c.Forced || cancelledForced
+
+			// A full build has to be followed by another full build. A quick
+			// one is followed by one including its paths as well as new ones.
+			if cancelledQuickPaths == nil {
+				lastChangedSources = nil
+			} else {
+				if lastChangedSources == nil {
+					lastChangedSources = make(map[string]struct{})
+				}
+				for path := range cancelledQuickPaths {
+					lastChangedSources[path] = struct{}{}
+				}
+			}
 		}
 	}
 }
@@ -261,6 +472,18 @@ func initConfigDefaults(config *Config) *Config {
 		config = &Config{}
 	}
 
+	switch config.ChangeDetection {
+	case "":
+		config.ChangeDetection = ChangeDetectionModTime
+	case ChangeDetectionHash, ChangeDetectionModTime, ChangeDetectionModTimeThenHash:
+	default:
+		exitWithError(xerrors.Errorf("unknown change detection strategy: %q", config.ChangeDetection))
+	}
+
+	if config.CompressMinSize <= 0 {
+		config.CompressMinSize = 1024
+	}
+
 	if config.Concurrency <= 0 {
 		config.Concurrency = 50
 	}
@@ -284,15 +507,26 @@ func initConfigDefaults(config *Config) *Config {
 func initContext(config *Config, watcher *fsnotify.Watcher) *Context {
 	config = initConfigDefaults(config)
 
+	pool := NewPool(config.Log, config.Concurrency)
+	pool.FailFast = config.FailFast
+	pool.JobTimeout = config.JobTimeout
+
 	return NewContext(&Args{
-		Log:       config.Log,
-		LogColor:  config.LogColor,
-		Port:      config.Port,
-		Pool:      NewPool(config.Log, config.Concurrency),
-		SourceDir: config.SourceDir,
-		TargetDir: config.TargetDir,
-		Watcher:   watcher,
-		Websocket: config.Websocket,
+		BuildReportPath: config.BuildReportPath,
+		CacheKey:        config.CacheKey,
+		CachePath:       config.CachePath,
+		ChangeDetection: config.ChangeDetection,
+		CompressMinSize: config.CompressMinSize,
+		CompressOutputs: config.CompressOutputs,
+		Log:             config.Log,
+		LogColor:        config.LogColor,
+		Port:            config.Port,
+		Pool:            pool,
+		SourceDir:       config.SourceDir,
+		TargetDir:       config.TargetDir,
+		TracePath:       config.TracePath,
+		Watcher:         watcher,
+		Websocket:       config.Websocket,
 	})
 }
 
@@ -315,7 +549,9 @@ func mapKeys(m map[string]struct{}) []string {
 func shutdownAndExec(c *Context, finish chan struct{},
 	watcher *fsnotify.Watcher, server *http.Server,
 ) {
-	// Tell the build loop to finish up
+	// Tell the build loop to finish up, interrupting any build that's in
+	// progress.
+	c.cancelBuild(xerrors.New("shutting down"))
 	finish <- struct{}{}
 
 	// DANGER: Defers don't seem to get called on the re-exec, so even though
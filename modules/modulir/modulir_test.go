@@ -1,5 +1,48 @@
 package modulir
 
-//"testing"
+import (
+	"sync"
+	"testing"
+	"time"
 
-// assert "github.com/stretchr/testify/require"
+	"github.com/fsnotify/fsnotify"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestBuildScheduledRebuild(t *testing.T) {
+	watcher, err := fsnotify.NewWatcher()
+	assert.NoError(t, err)
+	defer watcher.Close()
+
+	c := initContext(&Config{
+		Concurrency: 2,
+		Log:         &Logger{Level: LevelWarn},
+		TargetDir:   t.TempDir(),
+	}, watcher)
+
+	var buildCompleteMu sync.Mutex
+	buildComplete := sync.NewCond(&buildCompleteMu)
+	finish := make(chan struct{}, 1)
+
+	builds := make(chan bool, 10)
+	go build(c, func(c *Context) []error {
+		if c.FirstRun {
+			c.RebuildAt(time.Now().Add(50 * time.Millisecond))
+		}
+		builds <- c.Forced
+		return nil
+	}, finish, buildComplete)
+
+	// The first build isn't forced.
+	assert.False(t, <-builds)
+
+	// The scheduled one is.
+	select {
+	case forced := <-builds:
+		assert.True(t, forced)
+	case <-time.After(5 * time.Second):
+		assert.FailNow(t, "timed out waiting for scheduled rebuild")
+	}
+
+	finish <- struct{}{}
+}
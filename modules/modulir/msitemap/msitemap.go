@@ -0,0 +1,255 @@
+// Package msitemap produces sitemaps conforming to the sitemaps.org protocol,
+// including Google's image and video extensions. Sitemaps that would exceed
+// the protocol's URL limit are automatically split into several files joined
+// by a sitemap index.
+package msitemap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"path"
+	"strconv"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"coolstercodes/modules/modulir/mfile"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Public
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// MaxURLs is the maximum number of URLs that the protocol allows in a single
+// sitemap file.
+const MaxURLs = 50000
+
+// URL is a single location in a sitemap.
+type URL struct {
+	// Loc is the absolute URL of the page.
+	Loc string
+
+	// LastMod is the last time the page was modified. It's omitted if zero.
+	LastMod time.Time
+
+	// Images are images that appear on the page.
+	Images []*Image
+
+	// Videos are videos that appear on the page.
+	Videos []*Video
+}
+
+// MarshalXML encodes the URL as a `<url>` element, formatting LastMod as the
+// protocol expects.
+func (u *URL) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(&urlXML{
+		Loc:     u.Loc,
+		LastMod: formatTime(u.LastMod),
+		Images:  u.Images,
+		Videos:  u.Videos,
+	}, start)
+}
+
+// Image is an image on a page as described by Google's image sitemap
+// extension.
+type Image struct {
+	// Loc is the absolute URL of the image.
+	Loc string `xml:"image:loc"`
+}
+
+// Video is a video on a page as described by Google's video sitemap
+// extension.
+type Video struct {
+	// ThumbnailLoc is the absolute URL of a thumbnail image for the video.
+	ThumbnailLoc string
+
+	// Title is the title of the video.
+	Title string
+
+	// Description is a plain text description of the video.
+	Description string
+
+	// PlayerLoc is the absolute URL of an embeddable player for the video.
+	PlayerLoc string
+
+	// PublicationDate is when the video was published. It's omitted if
+	// zero.
+	PublicationDate time.Time
+}
+
+// MarshalXML encodes the video as a `<video:video>` element, formatting
+// PublicationDate as the protocol expects.
+func (v *Video) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(&videoXML{
+		ThumbnailLoc:    v.ThumbnailLoc,
+		Title:           v.Title,
+		Description:     v.Description,
+		PlayerLoc:       v.PlayerLoc,
+		PublicationDate: formatTime(v.PublicationDate),
+	}, start)
+}
+
+// Write writes a sitemap containing the given URLs to sitemap.xml in
+// targetDir. If there are more URLs than the protocol allows in a single
+// file, they're split across sitemap-1.xml, sitemap-2.xml, etc., and
+// sitemap.xml is written as an index pointing to each of them. absoluteURL is
+// the URL at which targetDir is hosted, and is used to build the index.
+//
+// Returns the base names of all files written.
+func Write(targetDir, absoluteURL string, urls []*URL) ([]string, error) {
+	if len(urls) <= maxURLsPerSitemap {
+		if err := writeFile(path.Join(targetDir, "sitemap.xml"), &urlSet{URLs: urls}); err != nil {
+			return nil, err
+		}
+		return []string{"sitemap.xml"}, nil
+	}
+
+	index := &sitemapIndex{}
+	var filenames []string
+
+	for i := 0; i < len(urls); i += maxURLsPerSitemap {
+		chunk := urls[i:min(i+maxURLsPerSitemap, len(urls))]
+		filename := "sitemap-" + strconv.Itoa(i/maxURLsPerSitemap+1) + ".xml"
+
+		if err := writeFile(path.Join(targetDir, filename), &urlSet{URLs: chunk}); err != nil {
+			return nil, err
+		}
+
+		entry := &sitemapIndexEntry{
+			Loc:     absoluteURL + "/" + filename,
+			LastMod: formatTime(latestLastMod(chunk)),
+		}
+
+		index.Sitemaps = append(index.Sitemaps, entry)
+		filenames = append(filenames, filename)
+	}
+
+	if err := writeFile(path.Join(targetDir, "sitemap.xml"), index); err != nil {
+		return nil, err
+	}
+
+	return append(filenames, "sitemap.xml"), nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// The maximum number of URLs to put into a single sitemap. A variable so that
+// it can be lowered in tests.
+var maxURLsPerSitemap = MaxURLs
+
+type urlSet struct {
+	XMLName    xml.Name `xml:"urlset"`
+	XMLNS      string   `xml:"xmlns,attr"`
+	XMLNSImage string   `xml:"xmlns:image,attr"`
+	XMLNSVideo string   `xml:"xmlns:video,attr"`
+	URLs       []*URL   `xml:"url"`
+}
+
+// The encoded form of URL. Note that field order is significant because the
+// protocol's schema expects elements in a particular sequence.
+type urlXML struct {
+	Loc     string   `xml:"loc"`
+	LastMod string   `xml:"lastmod,omitempty"`
+	Images  []*Image `xml:"image:image,omitempty"`
+	Videos  []*Video `xml:"video:video,omitempty"`
+}
+
+// The encoded form of Video. As with urlXML, field order is significant.
+type videoXML struct {
+	ThumbnailLoc    string `xml:"video:thumbnail_loc"`
+	Title           string `xml:"video:title"`
+	Description     string `xml:"video:description"`
+	PlayerLoc       string `xml:"video:player_loc"`
+	PublicationDate string `xml:"video:publication_date,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name             `xml:"sitemapindex"`
+	XMLNS    string               `xml:"xmlns,attr"`
+	Sitemaps []*sitemapIndexEntry `xml:"sitemap"`
+}
+
+type sitemapIndexEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// Encodes a URL set, setting namespaces along the way.
+func (s *urlSet) encode(w io.Writer) error {
+	s.XMLNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	s.XMLNSImage = "http://www.google.com/schemas/sitemap-image/1.1"
+	s.XMLNSVideo = "http://www.google.com/schemas/sitemap-video/1.1"
+	return encodeXML(w, s)
+}
+
+func (s *sitemapIndex) encode(w io.Writer) error {
+	s.XMLNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	return encodeXML(w, s)
+}
+
+func encodeXML(w io.Writer, v interface{}) error {
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return xerrors.Errorf("error writing XML header: %w", err)
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return xerrors.Errorf("error encoding sitemap: %w", err)
+	}
+
+	if _, err := w.Write([]byte("\n")); err != nil {
+		return xerrors.Errorf("error writing trailing newline: %w", err)
+	}
+
+	return nil
+}
+
+// Formats a time in the W3C datetime format used by sitemaps, or as an empty
+// string (so that it's omitted) if it's zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func latestLastMod(urls []*URL) time.Time {
+	var latest time.Time
+	for _, u := range urls {
+		if u.LastMod.After(latest) {
+			latest = u.LastMod
+		}
+	}
+	return latest
+}
+
+// Encodes v and writes it to target atomically so that an interrupted write
+// never leaves a truncated sitemap behind.
+func writeFile(target string, v interface{ encode(io.Writer) error }) error {
+	var buf bytes.Buffer
+	if err := v.encode(&buf); err != nil {
+		return err
+	}
+
+	if err := mfile.WriteFileAtomic(target, buf.Bytes(), 0o644); err != nil {
+		return xerrors.Errorf("error writing sitemap file: %w", err)
+	}
+
+	return nil
+}
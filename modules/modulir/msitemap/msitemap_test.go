@@ -0,0 +1,92 @@
+package msitemap
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	publishedAt := time.Date(2024, 5, 2, 18, 33, 36, 0, time.UTC)
+
+	filenames, err := Write(dir, "https://example.com", []*URL{
+		{Loc: "https://example.com"},
+		{
+			Loc:     "https://example.com/euclid",
+			LastMod: publishedAt,
+			Images:  []*Image{{Loc: "https://example.com/content/images/euclid/Euclid.png"}},
+			Videos: []*Video{{
+				ThumbnailLoc:    "https://img.youtube.com/vi/abc/hqdefault.jpg",
+				Title:           "Euclid",
+				Description:     "Quite the baller",
+				PlayerLoc:       "https://www.youtube.com/embed/abc",
+				PublicationDate: publishedAt,
+			}},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sitemap.xml"}, filenames)
+
+	data, err := os.ReadFile(path.Join(dir, "sitemap.xml"))
+	assert.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:image="http://www.google.com/schemas/sitemap-image/1.1" xmlns:video="http://www.google.com/schemas/sitemap-video/1.1">
+  <url>
+    <loc>https://example.com</loc>
+  </url>
+  <url>
+    <loc>https://example.com/euclid</loc>
+    <lastmod>2024-05-02T18:33:36Z</lastmod>
+    <image:image>
+      <image:loc>https://example.com/content/images/euclid/Euclid.png</image:loc>
+    </image:image>
+    <video:video>
+      <video:thumbnail_loc>https://img.youtube.com/vi/abc/hqdefault.jpg</video:thumbnail_loc>
+      <video:title>Euclid</video:title>
+      <video:description>Quite the baller</video:description>
+      <video:player_loc>https://www.youtube.com/embed/abc</video:player_loc>
+      <video:publication_date>2024-05-02T18:33:36Z</video:publication_date>
+    </video:video>
+  </url>
+</urlset>
+`, string(data))
+}
+
+func TestWriteIndex(t *testing.T) {
+	defer func(max int) { maxURLsPerSitemap = max }(maxURLsPerSitemap)
+	maxURLsPerSitemap = 2
+
+	dir := t.TempDir()
+	publishedAt := time.Date(2024, 5, 2, 18, 33, 36, 0, time.UTC)
+
+	filenames, err := Write(dir, "https://example.com", []*URL{
+		{Loc: "https://example.com/a", LastMod: publishedAt},
+		{Loc: "https://example.com/b"},
+		{Loc: "https://example.com/c"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sitemap-1.xml", "sitemap-2.xml", "sitemap.xml"}, filenames)
+
+	data, err := os.ReadFile(path.Join(dir, "sitemap.xml"))
+	assert.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap>
+    <loc>https://example.com/sitemap-1.xml</loc>
+    <lastmod>2024-05-02T18:33:36Z</lastmod>
+  </sitemap>
+  <sitemap>
+    <loc>https://example.com/sitemap-2.xml</loc>
+  </sitemap>
+</sitemapindex>
+`, string(data))
+
+	data, err = os.ReadFile(path.Join(dir, "sitemap-2.xml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "<loc>https://example.com/c</loc>")
+	assert.NotContains(t, string(data), "<loc>https://example.com/a</loc>")
+}
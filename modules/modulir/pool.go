@@ -1,8 +1,11 @@
 package modulir
 
 import (
+	"context"
 	"errors"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,8 +25,30 @@ import (
 // Job is a wrapper for a piece of work that should be executed by the job
 // pool.
 //
+// A job may declare dependencies on other jobs with Deps and DepNames, in
+// which case the pool won't run it until all of them have finished
+// successfully. If any dependency fails, the job fails without running.
+// Dependencies form a graph that must be acyclic -- jobs found to be part of
+// a cycle are failed.
+//
 //nolint:errname
 type Job struct {
+	// DepNames are the names of jobs that must finish successfully before
+	// this job runs. They're an alternative to Deps for when a handle to a
+	// job isn't easily available.
+	//
+	// Names are matched against jobs enqueued in the same round. A name
+	// matches all jobs with that name that have been enqueued by the time
+	// this job is, or if there are none, the first one enqueued after it.
+	// A name that never matches a job fails this job once all other work in
+	// the round is done.
+	DepNames []string
+
+	// Deps are jobs that must finish successfully before this job runs. They
+	// should be enqueued in the same round as this job, or have already
+	// finished in an earlier one.
+	Deps []*Job
+
 	// Duration is the time it took the job to run. It's set regardless of
 	// whether the job's finished state was executed, not executed, or errored.
 	Duration time.Duration
@@ -34,12 +59,60 @@ type Job struct {
 	// Executed is whether the job "did work", signaled by it returning true.
 	Executed bool
 
-	// F is the function which makes up the job's workload.
+	// F is the function which makes up the job's workload. Exactly one of F
+	// or FCtx should be set.
 	F func() (bool, error)
 
+	// FCtx is a variant of F that receives a context. The context is
+	// cancelled when the job's timeout elapses (see Timeout), when another
+	// job errors and the pool is configured to fail fast, or when the round
+	// is cancelled (e.g. because a build loop was interrupted by a new
+	// change). Jobs should stop working and return the context's error as
+	// soon as possible after it's cancelled.
+	FCtx func(ctx context.Context) (bool, error)
+
 	// Name is a name for the job which is helpful for informational and
-	// debugging purposes.
+	// debugging purposes. It's also used to match DepNames.
 	Name string
+
+	// Round is the number of the round in which the job was last enqueued.
+	Round int
+
+	// Start is the time at which the job last started running.
+	Start time.Time
+
+	// Timeout is the maximum amount of time that the job may run for. Once
+	// elapsed, the job is failed and its context cancelled. Because
+	// Goroutines can't be killed, a job that doesn't respect its context
+	// keeps running in the background, and its round doesn't finish until it
+	// returns so that it can't write outputs or enqueue jobs into the next
+	// one.
+	//
+	// Defaults to the pool's JobTimeout if zero.
+	Timeout time.Duration
+
+	// Worker is the number of the worker that last ran the job, or -1 if it
+	// never ran (e.g. because one of its dependencies failed).
+	Worker int
+
+	// dependents are jobs in the same round that depend on this one.
+	dependents []*Job
+
+	// deps are dependencies that hadn't finished when they were added,
+	// including those matched by DepNames.
+	deps []*Job
+
+	// numDepsLeft is the number of dependencies that haven't finished yet.
+	numDepsLeft int
+
+	// roundSeq identifies the round in which the job was enqueued.
+	roundSeq int
+
+	// state is the job's scheduling state.
+	state jobState
+
+	// unresolvedNames are DepNames that haven't matched a job yet.
+	unresolvedNames []string
 }
 
 // NewJob initializes and returns a new Job.
@@ -47,6 +120,12 @@ func NewJob(name string, f func() (bool, error)) *Job {
 	return &Job{Name: name, F: f}
 }
 
+// NewJobCtx initializes and returns a new Job whose function receives a
+// context (see Job.FCtx).
+func NewJobCtx(name string, f func(ctx context.Context) (bool, error)) *Job {
+	return &Job{Name: name, FCtx: f}
+}
+
 // Error returns the error message of the error wrapped in the job if this was
 // an errored job. Job implements the error interface so that it can return
 // itself in situations where error handling is being done but job errors may
@@ -65,6 +144,17 @@ func (j *Job) Error() string {
 // Pool is a worker group that runs a number of jobs at a configured
 // concurrency.
 type Pool struct {
+	// FailFast causes the first job to error in a round to cancel the
+	// contexts of all other jobs in it. Jobs that haven't started yet are
+	// failed without running.
+	FailFast bool
+
+	// JobTimeout is the default timeout for jobs that don't set their own
+	// (see Job.Timeout).
+	//
+	// Defaults to no timeout if zero.
+	JobTimeout time.Duration
+
 	Jobs chan *Job
 
 	// JobsAll is a slice of all the jobs that were fed into the pool on the
@@ -80,17 +170,21 @@ type Pool struct {
 	// JobsExecuted is a slice of jobs that were executed on the last run.
 	JobsExecuted []*Job
 
+	cancel         context.CancelCauseFunc
 	colorizer      *colorizer
 	concurrency    int
-	jobsInternal   chan *Job
+	ctx            context.Context
 	jobsErroredMu  sync.Mutex
 	jobsExecutedMu sync.Mutex
 	jobsFeederDone chan struct{}
 	log            LoggerInterface
 	roundNum       int
+	roundSeq       int
 	roundStarted   bool
+	sched          *jobScheduler
 	wg             sync.WaitGroup
 	workerInfos    []workerInfo
+	workersWG      sync.WaitGroup
 }
 
 // NewPool initializes a new pool with the given jobs and at the given
@@ -189,10 +283,19 @@ func (p *Pool) LogSlowestSlice(jobs []*Job) {
 // StartRound begins an execution round. Internal statistics and other tracking
 // are all reset.
 func (p *Pool) StartRound(roundNum int) {
+	p.StartRoundContext(context.Background(), roundNum)
+}
+
+// StartRoundContext is like StartRound, but the contexts of jobs in the round
+// derive from ctx so that cancelling it cancels all of them. Jobs that
+// haven't started by the time ctx is cancelled are failed without running.
+func (p *Pool) StartRoundContext(ctx context.Context, roundNum int) {
 	if p.roundStarted {
 		panic("StartRound already called (call Wait before calling it again)")
 	}
 
+	p.ctx, p.cancel = context.WithCancelCause(ctx)
+
 	p.roundNum = roundNum
 	p.log.Debugf("pool: Starting round %v at concurrency %v", p.roundNum, p.concurrency)
 
@@ -201,8 +304,9 @@ func (p *Pool) StartRound(roundNum int) {
 	p.JobsErrored = nil
 	p.JobsExecuted = nil
 	p.jobsFeederDone = make(chan struct{}, 1)
-	p.jobsInternal = make(chan *Job, 500)
+	p.roundSeq++
 	p.roundStarted = true
+	p.sched = newJobScheduler()
 
 	for i := range p.workerInfos {
 		p.workerInfos[i].reset()
@@ -213,9 +317,7 @@ func (p *Pool) StartRound(roundNum int) {
 		p.log.Debugf("pool: Job feeder: Starting")
 
 		for job := range p.Jobs {
-			p.wg.Add(1)
-			p.jobsInternal <- job
-			p.JobsAll = append(p.JobsAll, job)
+			p.Enqueue(job)
 		}
 
 		p.log.Debugf("pool: Job feeder: Finished feeding")
@@ -227,12 +329,72 @@ func (p *Pool) StartRound(roundNum int) {
 	// Worker Goroutines
 	for i := range p.concurrency {
 		workerNum := i
+		sched := p.sched
+		p.workersWG.Add(1)
 		go func() {
-			p.workForRound(workerNum)
+			defer p.workersWG.Done()
+			p.workForRound(workerNum, sched)
 		}()
 	}
 }
 
+// Enqueue adds a job to the current round, scheduling it to run as soon as
+// its dependencies have finished. Unlike sending a job over Jobs, it's safe to
+// call from within a running job, even after Wait has been called, which
+// allows jobs to enqueue more work. Returns the job so that it can be used as
+// a dependency of other jobs.
+func (p *Pool) Enqueue(job *Job) *Job {
+	s := p.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.wg.Add(1)
+	p.JobsAll = append(p.JobsAll, job)
+
+	job.Round = p.roundNum
+	job.Worker = -1
+
+	job.dependents = nil
+	job.deps = nil
+	job.numDepsLeft = 0
+	job.roundSeq = p.roundSeq
+	job.state = jobStateBlocked
+	job.unresolvedNames = nil
+
+	s.blocked[job] = struct{}{}
+	s.byName[job.Name] = append(s.byName[job.Name], job)
+
+	// Jobs waiting on a name that matched nothing until now.
+	for _, waiting := range s.waitingOnName[job.Name] {
+		waiting.unresolvedNames = slices.DeleteFunc(waiting.unresolvedNames,
+			func(name string) bool { return name == job.Name })
+		p.addDependencyLocked(waiting, job)
+	}
+	delete(s.waitingOnName, job.Name)
+
+	for _, dep := range job.Deps {
+		p.addDependencyLocked(job, dep)
+	}
+
+	for _, name := range job.DepNames {
+		deps := s.byName[name]
+		if len(deps) < 1 {
+			job.unresolvedNames = append(job.unresolvedNames, name)
+			s.waitingOnName[name] = append(s.waitingOnName[name], job)
+			continue
+		}
+
+		for _, dep := range deps {
+			if dep != job {
+				p.addDependencyLocked(job, dep)
+			}
+		}
+	}
+
+	p.releaseIfReadyLocked(job)
+	return job
+}
+
 // Wait waits until all jobs are finished and stops the pool.
 //
 // Returns true if the round of jobs all executed successfully, and false
@@ -254,9 +416,16 @@ func (p *Pool) Wait() bool {
 	close(p.Jobs)
 
 	// Now wait for the job feeder to be finished so that we know all jobs have
-	// been enqueued in jobsInternal.
+	// been enqueued.
 	<-p.jobsFeederDone
 
+	// From here, new jobs can only come from running jobs, so if nothing is
+	// running, any jobs still blocked never will be unblocked.
+	p.sched.mu.Lock()
+	p.sched.closing = true
+	p.failStuckJobsLocked()
+	p.sched.mu.Unlock()
+
 	// Prints some debug information to help us in case we run into stalling
 	// problems in the main job loop.
 	done := make(chan struct{}, 1)
@@ -268,7 +437,9 @@ func (p *Pool) Wait() bool {
 		}
 	}()
 
+	p.sched.mu.Lock()
 	p.log.Debugf("pool: Waiting for %v job(s) to be done", len(p.JobsAll))
+	p.sched.mu.Unlock()
 
 	// Now wait for all those jobs to be done.
 	p.wg.Wait()
@@ -276,9 +447,17 @@ func (p *Pool) Wait() bool {
 	// Kill the timeout Goroutine.
 	done <- struct{}{}
 
-	// Drops workers out of their run loop. Their Goroutines return.
-	// wait on the run gate.
-	close(p.jobsInternal)
+	// Drops workers out of their run loop. Wait for their Goroutines to
+	// return so that none are still touching worker state when the next
+	// round starts.
+	p.sched.mu.Lock()
+	p.sched.stopped = true
+	p.sched.cond.Broadcast()
+	p.sched.mu.Unlock()
+	p.workersWG.Wait()
+
+	// Release resources associated with the round's context.
+	p.cancel(nil)
 
 	// Occasionally useful for debugging.
 	// p.logWaitTimeoutInfo()
@@ -310,6 +489,283 @@ const (
 	waitSoftTimeout = 60 * time.Second
 )
 
+// The scheduling state of a job.
+type jobState int
+
+const (
+	// Enqueued, but waiting on dependencies. This is the zero value so that
+	// jobs that were never enqueued look blocked.
+	jobStateBlocked jobState = iota
+
+	// Dependencies are satisfied and the job is waiting for a worker or
+	// running.
+	jobStateReleased
+
+	// The job finished successfully.
+	jobStateSucceeded
+
+	// The job errored, or was failed because a dependency did.
+	jobStateFailed
+)
+
+// Per-round state for scheduling jobs according to their dependencies. A new
+// one is created for every round so that workers from a previous round can't
+// pick up jobs from the current one.
+type jobScheduler struct {
+	// blocked are jobs that have been enqueued, but which are waiting on
+	// dependencies.
+	blocked map[*Job]struct{}
+
+	// byName maps names to enqueued jobs for resolving DepNames.
+	byName map[string][]*Job
+
+	// closing is set once Wait has been called and all jobs sent over the
+	// Jobs channel have been enqueued.
+	closing bool
+
+	// cond signals workers that a job is ready or that the round has stopped.
+	cond *sync.Cond
+
+	// mu synchronizes access to the scheduler's fields and to the scheduling
+	// fields of jobs enqueued with it.
+	mu sync.Mutex
+
+	// numReleased is the number of jobs that are ready or running.
+	numReleased int
+
+	// ready are jobs waiting for a worker, in the order they became ready.
+	ready []*Job
+
+	// stopped is set once all jobs in the round are finished.
+	stopped bool
+
+	// waitingOnName maps names that haven't matched a job yet to jobs that
+	// depend on them.
+	waitingOnName map[string][]*Job
+}
+
+func newJobScheduler() *jobScheduler {
+	s := &jobScheduler{
+		blocked:       make(map[*Job]struct{}),
+		byName:        make(map[string][]*Job),
+		waitingOnName: make(map[string][]*Job),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Blocks until a job is ready, returning it, or until the round is stopped,
+// returning nil.
+func (s *jobScheduler) next() *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.ready) < 1 {
+		if s.stopped {
+			return nil
+		}
+		s.cond.Wait()
+	}
+
+	job := s.ready[0]
+	s.ready = s.ready[1:]
+	return job
+}
+
+// Makes job depend on dep. Expects the scheduler's mutex to be held.
+func (p *Pool) addDependencyLocked(job, dep *Job) {
+	switch {
+	case dep.state == jobStateSucceeded:
+		// Already done, possibly in an earlier round.
+
+	case dep.state == jobStateFailed:
+		// Dependents of the failed job have been failed already, so job has
+		// to be failed when the dependency is added.
+		p.failJobLocked(job, xerrors.Errorf("dependency '%s' failed", dep.Name))
+
+	default:
+		// Note that a job that was never enqueued this round will never
+		// finish, which is detected as a stuck job.
+		job.deps = append(job.deps, dep)
+		job.numDepsLeft++
+		dep.dependents = append(dep.dependents, job)
+	}
+}
+
+// Fails a job that hasn't run, and recursively all jobs depending on it.
+// Expects the scheduler's mutex to be held.
+func (p *Pool) failJobLocked(job *Job, err error) {
+	if !p.markJobFailedLocked(job, err) {
+		return
+	}
+
+	p.failDependentsLocked(job)
+}
+
+// Fails the dependents of a failed job. Expects the scheduler's mutex to be
+// held.
+func (p *Pool) failDependentsLocked(job *Job) {
+	for _, dependent := range job.dependents {
+		p.failJobLocked(dependent, xerrors.Errorf("dependency '%s' failed", job.Name))
+	}
+}
+
+// Marks a blocked job as failed without touching its dependents. Returns
+// false if the job wasn't blocked. Expects the scheduler's mutex to be held.
+func (p *Pool) markJobFailedLocked(job *Job, err error) bool {
+	if job.state != jobStateBlocked {
+		return false
+	}
+
+	job.Err = err
+	job.state = jobStateFailed
+	delete(p.sched.blocked, job)
+
+	p.jobsErroredMu.Lock()
+	p.JobsErrored = append(p.JobsErrored, job)
+	p.jobsErroredMu.Unlock()
+
+	p.wg.Done()
+	return true
+}
+
+// Fails jobs that are blocked and will never become unblocked because
+// there's nothing left that could unblock them. This happens when all
+// enqueued jobs have been sent, nothing is running, and there are still
+// blocked jobs, which means that they depend on jobs that were never enqueued,
+// or on each other in a cycle. Expects the scheduler's mutex to be held.
+func (p *Pool) failStuckJobsLocked() {
+	s := p.sched
+
+	if !s.closing || s.numReleased > 0 || len(s.blocked) < 1 {
+		return
+	}
+
+	// Sort for deterministic error messages.
+	stuck := make([]*Job, 0, len(s.blocked))
+	for job := range s.blocked {
+		stuck = append(stuck, job)
+	}
+	sort.Slice(stuck, func(i, j int) bool { return stuck[i].Name < stuck[j].Name })
+
+	for _, job := range stuck {
+		if job.state != jobStateBlocked {
+			continue // failed as a dependent of an earlier stuck job
+		}
+
+		if len(job.unresolvedNames) > 0 {
+			p.failJobLocked(job, xerrors.Errorf("unknown dependency '%s'", job.unresolvedNames[0]))
+			continue
+		}
+
+		for _, dep := range job.deps {
+			if dep.state == jobStateBlocked && dep.roundSeq != p.roundSeq {
+				p.failJobLocked(job, xerrors.Errorf("dependency '%s' was never enqueued", dep.Name))
+				break
+			}
+		}
+	}
+
+	// Everything left must be part of, or depend on, a cycle.
+	for _, job := range stuck {
+		if job.state != jobStateBlocked {
+			continue
+		}
+
+		if cycle := findDependencyCycle(job); cycle != nil {
+			names := make([]string, len(cycle))
+			for i, j := range cycle {
+				names[i] = j.Name
+			}
+			err := xerrors.Errorf("dependency cycle: %s", strings.Join(names, " -> "))
+
+			// Mark the whole cycle before failing dependents so that each
+			// member's error reports the cycle rather than another member.
+			members := cycle[:len(cycle)-1]
+			for _, j := range members {
+				p.markJobFailedLocked(j, err)
+			}
+			for _, j := range members {
+				p.failDependentsLocked(j)
+			}
+		}
+	}
+}
+
+// Finds a cycle of blocked jobs reachable from start by following
+// dependencies, returning it as a path that starts and ends at the same job,
+// or nil if there isn't one.
+func findDependencyCycle(start *Job) []*Job {
+	var path []*Job
+	onPath := make(map[*Job]int)
+	visited := make(map[*Job]struct{})
+
+	var visit func(job *Job) []*Job
+	visit = func(job *Job) []*Job {
+		if i, ok := onPath[job]; ok {
+			return append(slices.Clone(path[i:]), job)
+		}
+		if _, ok := visited[job]; ok {
+			return nil
+		}
+		visited[job] = struct{}{}
+
+		onPath[job] = len(path)
+		path = append(path, job)
+
+		// Only blocked jobs can be part of a cycle.
+		for _, dep := range job.deps {
+			if dep.state != jobStateBlocked {
+				continue
+			}
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+
+		path = path[:len(path)-1]
+		delete(onPath, job)
+		return nil
+	}
+
+	return visit(start)
+}
+
+// Marks a job as finished, releasing or failing its dependents. Expects the
+// scheduler's mutex to be held.
+func (p *Pool) finishJobLocked(job *Job) {
+	p.sched.numReleased--
+
+	if job.Err != nil {
+		job.state = jobStateFailed
+		p.failDependentsLocked(job)
+	} else {
+		job.state = jobStateSucceeded
+		for _, dependent := range job.dependents {
+			dependent.numDepsLeft--
+			p.releaseIfReadyLocked(dependent)
+		}
+	}
+
+	p.failStuckJobsLocked()
+}
+
+// Moves a blocked job to the ready queue if all its dependencies are
+// satisfied. Expects the scheduler's mutex to be held.
+func (p *Pool) releaseIfReadyLocked(job *Job) {
+	s := p.sched
+
+	if job.state != jobStateBlocked || job.numDepsLeft > 0 || len(job.unresolvedNames) > 0 {
+		return
+	}
+
+	job.state = jobStateReleased
+	delete(s.blocked, job)
+	s.numReleased++
+	s.ready = append(s.ready, job)
+	s.cond.Signal()
+}
+
 // Keeps track of the information on a worker. Used for debugging purposes
 // only.
 type workerInfo struct {
@@ -361,7 +817,7 @@ func (p *Pool) logWaitTimeoutInfo() {
 		numJobsFinished,
 		len(p.JobsErrored),
 		len(p.JobsExecuted),
-		len(p.jobsInternal),
+		p.numJobsReady(),
 	)
 
 	for i, info := range p.workerInfos {
@@ -388,6 +844,10 @@ func (p *Pool) setWorkerJobFinished(workerNum int, job *Job, executed bool, err
 		p.jobsErroredMu.Unlock()
 
 		p.workerInfos[workerNum].numJobsErrored++
+
+		if p.FailFast && p.cancel != nil {
+			p.cancel(xerrors.Errorf("job '%s' errored", job.Name))
+		}
 	}
 
 	if executed {
@@ -400,13 +860,37 @@ func (p *Pool) setWorkerJobFinished(workerNum int, job *Job, executed bool, err
 		p.workerInfos[workerNum].numJobsExecuted++
 	}
 
+	// Jobs run directly in tests aren't scheduled.
+	if job.state == jobStateReleased {
+		p.sched.mu.Lock()
+		p.finishJobLocked(job)
+		p.sched.mu.Unlock()
+	}
+
 	p.wg.Done()
 
 	p.workerInfos[workerNum].activeJob = nil
 	p.workerInfos[workerNum].state = workerStateJobFinished
 }
 
+// Returns the number of jobs enqueued in the current round, including any
+// still waiting in the Jobs channel.
+func (p *Pool) numJobs() int {
+	p.sched.mu.Lock()
+	defer p.sched.mu.Unlock()
+
+	return len(p.JobsAll) + len(p.Jobs)
+}
+
+func (p *Pool) numJobsReady() int {
+	p.sched.mu.Lock()
+	defer p.sched.mu.Unlock()
+
+	return len(p.sched.ready)
+}
+
 func (p *Pool) setWorkerJobExecuting(workerNum int, job *Job) {
+	job.Worker = workerNum
 	p.workerInfos[workerNum].activeJob = job
 	p.workerInfos[workerNum].state = workerStateJobExecuting
 }
@@ -419,11 +903,12 @@ func sortJobsBySlowest(jobs []*Job) {
 }
 
 // The work loop for a single round within a single worker Goroutine.
-func (p *Pool) workForRound(workerNum int) {
-	for j := range p.jobsInternal {
-		// Required so that we have a stable pointer that we can keep past the
-		// lifetime of the loop. Don't change this.
-		job := j
+func (p *Pool) workForRound(workerNum int, sched *jobScheduler) {
+	for {
+		job := sched.next()
+		if job == nil {
+			break
+		}
 
 		p.workJob(workerNum, job)
 	}
@@ -436,11 +921,26 @@ func (p *Pool) workForRound(workerNum int) {
 func (p *Pool) workJob(workerNum int, job *Job) {
 	p.setWorkerJobExecuting(workerNum, job)
 
-	// Start a Goroutine to track the time taken to do this work.
-	// Unfortunately, we can't actually kill a timed out Goroutine because
-	// Go (and we rely on the user to make sure these get fixed instead),
-	// but we can at least raise on the interface which job is problematic
-	// to help identify what needs to be fixed.
+	ctx := p.ctx
+	if ctx == nil {
+		ctx = context.Background() // jobs run directly in tests
+	}
+
+	timeout := job.Timeout
+	if timeout == 0 {
+		timeout = p.JobTimeout
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Start a Goroutine to track the time taken to do this work. This is
+	// mostly useful for jobs without a timeout, which may run forever, but
+	// we can at least raise on the interface which job is problematic to
+	// help identify what needs to be fixed.
 	done := make(chan struct{}, 1)
 	go func() {
 		select {
@@ -450,41 +950,109 @@ func (p *Pool) workJob(workerNum int, job *Job) {
 		}
 	}()
 
-	var executed bool
-	var jobErr error
 	start := time.Now()
+	job.Start = start
+
+	var res jobResult
+	if ctx.Err() != nil {
+		// The round was cancelled before the job got a chance to start.
+		res.err = xerrors.Errorf("job cancelled before starting: %w", context.Cause(ctx))
+	} else {
+		res = runJob(ctx, job, timeout)
+	}
 
-	defer func() {
-		job.Duration = time.Since(start)
+	job.Duration = time.Since(start)
 
-		// Kill the timeout Goroutine.
-		done <- struct{}{}
+	// Kill the timeout Goroutine.
+	done <- struct{}{}
+
+	// An abandoned job is still running, so hold the round open until it
+	// returns. Otherwise it might keep writing outputs after they've been
+	// pruned, or enqueue jobs into the round that follows.
+	if res.abandoned != nil {
+		p.log.Warnf("Job abandoned; waiting for it to return before finishing round (job: '%s')",
+			job.Name)
 
-		var panicked bool
-		if r := recover(); r != nil {
-			if err, ok := r.(error); ok {
-				jobErr = xerrors.Errorf("job panicked: %w", err)
-			} else {
-				// Panics are often just given a string to panic with, so make
-				// sure to handle that as well
-				jobErr = xerrors.Errorf("job panicked: %v", r)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			<-res.abandoned
+			p.log.Debugf("Abandoned job returned (job: '%s')", job.Name)
+		}()
+	}
+
+	p.setWorkerJobFinished(workerNum, job, res.executed, res.err)
+
+	// And set the special panicked worker status if we panicked because it
+	// means that this worker is down and no longer available.
+	//
+	// TODO: It is possible to hit a deadlock if all workers have panicked
+	// and there's still work left to do. The framework should detect this
+	// condition and exit.
+	if res.panicked {
+		p.workerInfos[workerNum].state = workerStatePanicked
+	}
+}
+
+// The outcome of running a job's function.
+type jobResult struct {
+	err      error
+	executed bool
+	panicked bool
+
+	// abandoned is set if the job was abandoned because its context finished
+	// while it was still running, and receives once its function returns.
+	abandoned <-chan jobResult
+}
+
+// Runs a job's function in its own Goroutine, recovering from any panic. If
+// ctx is done before the function returns, the job is abandoned and an error
+// is returned immediately. The function keeps running in the background
+// until it returns, but its result is discarded. The caller can wait on it
+// through the result's abandoned channel.
+func runJob(ctx context.Context, job *Job, timeout time.Duration) jobResult {
+	resChan := make(chan jobResult, 1)
+
+	go func() {
+		var res jobResult
+
+		defer func() {
+			if r := recover(); r != nil {
+				if err, ok := r.(error); ok {
+					res.err = xerrors.Errorf("job panicked: %w", err)
+				} else {
+					// Panics are often just given a string to panic with,
+					// so make sure to handle that as well
+					res.err = xerrors.Errorf("job panicked: %v", r)
+				}
+				res.executed = false
+				res.panicked = true
 			}
-			panicked = true
-		}
 
-		p.setWorkerJobFinished(workerNum, job, executed, jobErr)
-
-		// And set the special panicked worker status if we panicked
-		// because it means that this worker is down and no longer
-		// available.
-		//
-		// TODO: It is possible to hit a deadlock if all workers have
-		// panicked and there's still work left to do. The framework should
-		// detect this condition and exit.
-		if panicked {
-			p.workerInfos[workerNum].state = workerStatePanicked
+			resChan <- res
+		}()
+
+		if job.FCtx != nil {
+			res.executed, res.err = job.FCtx(ctx)
+		} else {
+			res.executed, res.err = job.F()
 		}
 	}()
 
-	executed, jobErr = job.F()
+	select {
+	case res := <-resChan:
+		return res
+
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return jobResult{
+				err:       xerrors.Errorf("job timed out after %v: %w", timeout, ctx.Err()),
+				abandoned: resChan,
+			}
+		}
+		return jobResult{
+			err:       xerrors.Errorf("job cancelled: %w", context.Cause(ctx)),
+			abandoned: resChan,
+		}
+	}
 }
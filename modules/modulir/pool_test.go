@@ -1,7 +1,11 @@
 package modulir
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	assert "github.com/stretchr/testify/require"
 	"golang.org/x/xerrors"
@@ -96,6 +100,241 @@ func TestWithError(t *testing.T) {
 	assert.Equal(t, "error", j2.Err.Error())
 }
 
+func TestWithDeps(t *testing.T) {
+	p := NewPool(&Logger{Level: LevelDebug}, 10)
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) func() (bool, error) {
+		return func() (bool, error) {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return true, nil
+		}
+	}
+
+	p.StartRound(0)
+
+	// Enqueued out of order and with a variety of handle and name
+	// dependencies.
+	j3 := NewJob("job 3", record("job 3"))
+	j3.DepNames = []string{"job 2"}
+	p.Enqueue(j3)
+
+	j0 := p.Enqueue(NewJob("job 0", record("job 0")))
+
+	j1 := NewJob("job 1", record("job 1"))
+	j1.Deps = []*Job{j0}
+	p.Enqueue(j1)
+
+	j2 := NewJob("job 2", record("job 2"))
+	j2.Deps = []*Job{j0, j1}
+	p.Enqueue(j2)
+
+	p.Wait()
+
+	assert.Len(t, p.JobsAll, 4)
+	assert.Empty(t, p.JobsErrored)
+	assert.Len(t, p.JobsExecuted, 4)
+	assert.Equal(t, []string{"job 0", "job 1", "job 2", "job 3"}, order)
+}
+
+func TestWithDepsEnqueuedByJob(t *testing.T) {
+	p := NewPool(&Logger{Level: LevelDebug}, 10)
+
+	p.StartRound(0)
+
+	var j1 *Job
+	j0 := p.Enqueue(NewJob("job 0", func() (bool, error) {
+		// Jobs can enqueue more jobs even after Wait has been called.
+		time.Sleep(10 * time.Millisecond)
+		j1 = p.Enqueue(NewJob("job 1", func() (bool, error) { return true, nil }))
+		return true, nil
+	}))
+
+	j2 := NewJob("job 2", func() (bool, error) { return true, nil })
+	j2.DepNames = []string{"job 1"}
+	p.Enqueue(j2)
+
+	p.Wait()
+
+	assert.Len(t, p.JobsAll, 3)
+	assert.Empty(t, p.JobsErrored)
+	assert.True(t, j0.Executed)
+	assert.True(t, j1.Executed)
+	assert.True(t, j2.Executed)
+}
+
+func TestWithDepsError(t *testing.T) {
+	p := NewPool(&Logger{Level: LevelDebug}, 10)
+
+	p.StartRound(0)
+	j0 := p.Enqueue(NewJob("job 0", func() (bool, error) { return true, xerrors.Errorf("error") }))
+
+	j1 := NewJob("job 1", func() (bool, error) { panic("should not run") })
+	j1.Deps = []*Job{j0}
+	p.Enqueue(j1)
+
+	j2 := NewJob("job 2", func() (bool, error) { panic("should not run") })
+	j2.DepNames = []string{"job 1"}
+	p.Enqueue(j2)
+
+	j3 := p.Enqueue(NewJob("job 3", func() (bool, error) { return true, nil }))
+	p.Wait()
+
+	assert.Len(t, p.JobsAll, 4)
+	assert.Len(t, p.JobsErrored, 3)
+	assert.Len(t, p.JobsExecuted, 2)
+
+	assert.False(t, j1.Executed)
+	assert.Equal(t, "dependency 'job 0' failed", j1.Err.Error())
+	assert.False(t, j2.Executed)
+	assert.Equal(t, "dependency 'job 1' failed", j2.Err.Error())
+	assert.True(t, j3.Executed)
+	assert.NoError(t, j3.Err)
+
+	// A failed dependency from an earlier round fails its dependents
+	// immediately.
+	p.StartRound(1)
+	j4 := NewJob("job 4", func() (bool, error) { panic("should not run") })
+	j4.Deps = []*Job{j0, j3}
+	p.Enqueue(j4)
+	p.Wait()
+
+	assert.Equal(t, "dependency 'job 0' failed", j4.Err.Error())
+}
+
+func TestWithDepsStuck(t *testing.T) {
+	p := NewPool(&Logger{Level: LevelDebug}, 10)
+
+	p.StartRound(0)
+
+	j0 := NewJob("job 0", func() (bool, error) { panic("should not run") })
+	j0.DepNames = []string{"job 1"}
+	p.Enqueue(j0)
+
+	j1 := NewJob("job 1", func() (bool, error) { panic("should not run") })
+	j1.DepNames = []string{"job 0"}
+	p.Enqueue(j1)
+
+	j2 := NewJob("job 2", func() (bool, error) { panic("should not run") })
+	j2.Deps = []*Job{j1}
+	p.Enqueue(j2)
+
+	j3 := NewJob("job 3", func() (bool, error) { panic("should not run") })
+	j3.DepNames = []string{"nonexistent"}
+	p.Enqueue(j3)
+
+	j4 := NewJob("job 4", func() (bool, error) { panic("should not run") })
+	j4.Deps = []*Job{NewJob("never enqueued", nil)}
+	p.Enqueue(j4)
+
+	j5 := p.Enqueue(NewJob("job 5", func() (bool, error) { return true, nil }))
+
+	p.Wait()
+
+	assert.Len(t, p.JobsErrored, 5)
+	assert.Equal(t, "dependency cycle: job 0 -> job 1 -> job 0", j0.Err.Error())
+	assert.Equal(t, "dependency cycle: job 0 -> job 1 -> job 0", j1.Err.Error())
+	assert.Equal(t, "dependency 'job 1' failed", j2.Err.Error())
+	assert.Equal(t, "unknown dependency 'nonexistent'", j3.Err.Error())
+	assert.Equal(t, "dependency 'never enqueued' was never enqueued", j4.Err.Error())
+	assert.True(t, j5.Executed)
+}
+
+func TestWithTimeout(t *testing.T) {
+	p := NewPool(&Logger{Level: LevelDebug}, 10)
+	p.JobTimeout = 50 * time.Millisecond
+
+	p.StartRound(0)
+
+	// Ignores its context, so it keeps running after it's abandoned.
+	var j0Returned atomic.Bool
+	j0 := p.Enqueue(NewJob("job 0", func() (bool, error) {
+		time.Sleep(200 * time.Millisecond)
+		j0Returned.Store(true)
+		return true, nil
+	}))
+
+	j1 := p.Enqueue(NewJobCtx("job 1", func(ctx context.Context) (bool, error) {
+		<-ctx.Done()
+		return true, ctx.Err()
+	}))
+
+	j2 := NewJobCtx("job 2", func(ctx context.Context) (bool, error) {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+			return true, nil
+		}
+	})
+	j2.Timeout = 10 * time.Second
+	p.Enqueue(j2)
+
+	p.Wait()
+
+	assert.Len(t, p.JobsErrored, 2)
+	assert.Equal(t, "job timed out after 50ms: context deadline exceeded", j0.Err.Error())
+	assert.False(t, j0.Executed)
+	assert.True(t, j0Returned.Load(), "round finished before abandoned job returned")
+	assert.ErrorIs(t, j1.Err, context.DeadlineExceeded)
+	assert.NoError(t, j2.Err)
+	assert.True(t, j2.Executed)
+}
+
+func TestWithCancel(t *testing.T) {
+	p := NewPool(&Logger{Level: LevelDebug}, 10)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	p.StartRoundContext(ctx, 0)
+
+	started := make(chan struct{})
+	j0 := p.Enqueue(NewJobCtx("job 0", func(ctx context.Context) (bool, error) {
+		close(started)
+		<-ctx.Done()
+		return true, nil
+	}))
+
+	<-started
+	cancel(xerrors.Errorf("new changes"))
+
+	p.Wait()
+
+	assert.Equal(t, "job cancelled: new changes", j0.Err.Error())
+
+	// Jobs in a round that's already cancelled never start.
+	p.StartRoundContext(ctx, 1)
+	j1 := p.Enqueue(NewJob("job 1", func() (bool, error) { panic("should not run") }))
+	p.Wait()
+
+	assert.Equal(t, "job cancelled before starting: new changes", j1.Err.Error())
+}
+
+func TestWithFailFast(t *testing.T) {
+	p := NewPool(&Logger{Level: LevelDebug}, 1)
+	p.FailFast = true
+
+	p.StartRound(0)
+	j0 := p.Enqueue(NewJob("job 0", func() (bool, error) { return true, xerrors.Errorf("error") }))
+	j1 := p.Enqueue(NewJob("job 1", func() (bool, error) { panic("should not run") }))
+	p.Wait()
+
+	assert.Len(t, p.JobsErrored, 2)
+	assert.Equal(t, "error", j0.Err.Error())
+	assert.Equal(t, "job cancelled before starting: job 'job 0' errored", j1.Err.Error())
+
+	// The next round starts out uncancelled.
+	p.StartRound(1)
+	j2 := p.Enqueue(NewJob("job 2", func() (bool, error) { return true, nil }))
+	p.Wait()
+
+	assert.NoError(t, j2.Err)
+}
+
 func TestWorkJob(t *testing.T) {
 	p := NewPool(&Logger{Level: LevelDebug}, 1)
 
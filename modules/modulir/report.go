@@ -0,0 +1,212 @@
+package modulir
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"coolstercodes/modules/modulir/internal/atomicfile"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Public
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// BuildReport is a machine-readable summary of a single build loop. If
+// BuildReportPath is configured, one is written after every loop so that it
+// can be archived, or checked for regressions in build time or job count.
+//
+// Durations are in fractional milliseconds.
+type BuildReport struct {
+	// ChangedPaths are the source paths that Context.Changed reported as
+	// changed during the build, sorted.
+	ChangedPaths []string `json:"changed_paths"`
+
+	// DurationMS is the total time that the build took.
+	DurationMS float64 `json:"duration_ms"`
+
+	// Errors are all errors produced by the build, including those of
+	// errored jobs.
+	Errors []string `json:"errors"`
+
+	// Forced is whether the build was forced, in which case Context.Changed
+	// reported every path as changed.
+	Forced bool `json:"forced"`
+
+	// Jobs are all jobs enqueued during the build, ordered by round and then
+	// by name.
+	Jobs []*BuildReportJob `json:"jobs"`
+
+	// LoopDurationMS is the time spent in the build function enqueuing jobs
+	// (see Stats.LoopDuration).
+	LoopDurationMS float64 `json:"loop_duration_ms"`
+
+	// NumJobs is the total number of jobs enqueued.
+	NumJobs int `json:"num_jobs"`
+
+	// NumJobsErrored is the number of jobs that errored.
+	NumJobsErrored int `json:"num_jobs_errored"`
+
+	// NumJobsExecuted is the number of jobs that did work.
+	NumJobsExecuted int `json:"num_jobs_executed"`
+
+	// NumRounds is the number of rounds in the build.
+	NumRounds int `json:"num_rounds"`
+
+	// Quick is whether the build ran in "quick rebuild mode" with only the
+	// paths that triggered it considered changed (see Context.QuickPaths).
+	Quick bool `json:"quick"`
+
+	// Start is when the build started.
+	Start time.Time `json:"start"`
+
+	// Success is whether the build completed without errors.
+	Success bool `json:"success"`
+
+	// TotalJobDurationMS is the sum of the durations of all executed jobs,
+	// which is how long the build would've taken without parallelism.
+	TotalJobDurationMS float64 `json:"total_job_duration_ms"`
+}
+
+// BuildReportJob describes a single job in a BuildReport.
+type BuildReportJob struct {
+	// DurationMS is the time the job took to run.
+	DurationMS float64 `json:"duration_ms"`
+
+	// Error is the job's error if it errored.
+	Error string `json:"error,omitempty"`
+
+	// Name is the job's name.
+	Name string `json:"name"`
+
+	// Round is the number of the round in which the job ran.
+	Round int `json:"round"`
+
+	// State is the job's final state. See the BuildReportJobState constants.
+	State BuildReportJobState `json:"state"`
+
+	// Worker is the number of the worker that ran the job, or -1 if it never
+	// ran (e.g. because one of its dependencies failed).
+	Worker int `json:"worker"`
+}
+
+// BuildReportJobState is the final state of a job in a BuildReport.
+type BuildReportJobState string
+
+// The possible final states of a job in a BuildReport.
+const (
+	// BuildReportJobStateErrored is a job that produced an error.
+	BuildReportJobStateErrored BuildReportJobState = "errored"
+
+	// BuildReportJobStateExecuted is a job that did work.
+	BuildReportJobStateExecuted BuildReportJobState = "executed"
+
+	// BuildReportJobStateSkipped is a job that ran, but found it had no work
+	// to do (usually because its sources hadn't changed).
+	BuildReportJobStateSkipped BuildReportJobState = "skipped"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Produces a report of the build loop that just finished from the context's
+// statistics. Expects to be called before Forced and QuickPaths are reset.
+func newBuildReport(c *Context, errors []error, duration time.Duration) *BuildReport {
+	report := &BuildReport{
+		ChangedPaths:       c.Stats.ChangedPaths(),
+		DurationMS:         durationMS(duration),
+		Errors:             make([]string, len(errors)),
+		Forced:             c.Forced,
+		Jobs:               make([]*BuildReportJob, len(c.Stats.JobsAll)),
+		LoopDurationMS:     durationMS(c.Stats.LoopDuration),
+		NumJobs:            c.Stats.NumJobs,
+		NumJobsErrored:     len(c.Stats.JobsErrored),
+		NumJobsExecuted:    len(c.Stats.JobsExecuted),
+		NumRounds:          c.Stats.NumRounds,
+		Quick:              c.QuickPaths != nil,
+		Start:              c.Stats.Start,
+		Success:            len(c.Stats.JobsErrored) == 0 && len(errors) < 1,
+		TotalJobDurationMS: durationMS(calculateTotalDuration(c.Stats.JobsExecuted)),
+	}
+
+	for i, err := range errors {
+		report.Errors[i] = err.Error()
+	}
+
+	for i, job := range c.Stats.JobsAll {
+		reportJob := &BuildReportJob{
+			DurationMS: durationMS(job.Duration),
+			Name:       job.Name,
+			Round:      job.Round,
+			State:      BuildReportJobStateSkipped,
+			Worker:     job.Worker,
+		}
+
+		switch {
+		case job.Err != nil:
+			reportJob.Error = job.Err.Error()
+			reportJob.State = BuildReportJobStateErrored
+		case job.Executed:
+			reportJob.State = BuildReportJobStateExecuted
+		}
+
+		report.Jobs[i] = reportJob
+	}
+
+	sort.SliceStable(report.Jobs, func(i, j int) bool {
+		if report.Jobs[i].Round != report.Jobs[j].Round {
+			return report.Jobs[i].Round < report.Jobs[j].Round
+		}
+		return report.Jobs[i].Name < report.Jobs[j].Name
+	})
+
+	return report
+}
+
+// Writes a build report to BuildReportPath, or to stdout if it's "-".
+func (c *Context) writeBuildReport(report *BuildReport) error {
+	if c.BuildReportPath == "-" {
+		data, err := json.Marshal(report)
+		if err != nil {
+			return xerrors.Errorf("error encoding build report: %w", err)
+		}
+
+		if _, err := os.Stdout.Write(append(data, '\n')); err != nil {
+			return xerrors.Errorf("error writing build report: %w", err)
+		}
+
+		return nil
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("error encoding build report: %w", err)
+	}
+
+	if err := atomicfile.Write(c.BuildReportPath, data, 0o644); err != nil {
+		return xerrors.Errorf("error writing build report: %w", err)
+	}
+
+	c.Log.Debugf("Wrote build report to '%s'", c.BuildReportPath)
+	return nil
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
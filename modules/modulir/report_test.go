@@ -0,0 +1,89 @@
+package modulir
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestBuildReport(t *testing.T) {
+	dir := t.TempDir()
+
+	source := filepath.Join(dir, "source.md")
+	assert.NoError(t, os.WriteFile(source, []byte("hello"), 0o600))
+
+	c := NewContext(&Args{
+		BuildReportPath: filepath.Join(dir, "reports", "report.json"),
+		Log:             &Logger{Level: LevelWarn},
+		Pool:            NewPool(&Logger{Level: LevelWarn}, 1),
+	})
+
+	c.ResetBuild()
+	c.StartRound()
+
+	j0 := c.AddJob("job 0", func() (bool, error) { return c.Changed(source), nil })
+	c.AddJob("job 1", func() (bool, error) { return false, nil })
+	c.AddJob("job 2", func() (bool, error) { return true, xerrors.Errorf("error") })
+	c.AddJobAfter("job 3", []*Job{j0}, func() (bool, error) { return true, nil })
+	errors := c.Wait()
+	c.Pool.Wait()
+
+	report := newBuildReport(c, errors, 0)
+	assert.NoError(t, c.writeBuildReport(report))
+
+	data, err := os.ReadFile(c.BuildReportPath)
+	assert.NoError(t, err)
+
+	var decoded BuildReport
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+
+	assert.Equal(t, []string{source}, decoded.ChangedPaths)
+	assert.Equal(t, []string{"error"}, decoded.Errors)
+	assert.Equal(t, 4, decoded.NumJobs)
+	assert.Equal(t, 1, decoded.NumJobsErrored)
+	assert.Equal(t, 3, decoded.NumJobsExecuted)
+	assert.Equal(t, 2, decoded.NumRounds)
+	assert.False(t, decoded.Quick)
+	assert.False(t, decoded.Success)
+
+	assert.Len(t, decoded.Jobs, 4)
+	for i, expected := range []BuildReportJob{
+		{Name: "job 0", State: BuildReportJobStateExecuted},
+		{Name: "job 1", State: BuildReportJobStateSkipped},
+		{Name: "job 2", State: BuildReportJobStateErrored, Error: "error"},
+		{Name: "job 3", State: BuildReportJobStateExecuted},
+	} {
+		job := decoded.Jobs[i]
+		assert.Equal(t, expected.Name, job.Name)
+		assert.Equal(t, expected.State, job.State)
+		assert.Equal(t, expected.Error, job.Error)
+		assert.Equal(t, 0, job.Round)
+		assert.Equal(t, 0, job.Worker)
+	}
+}
+
+func TestBuildReportNeverRun(t *testing.T) {
+	c := NewContext(&Args{
+		Log:  &Logger{Level: LevelWarn},
+		Pool: NewPool(&Logger{Level: LevelWarn}, 1),
+	})
+
+	c.ResetBuild()
+	c.StartRound()
+
+	j0 := c.AddJob("job 0", func() (bool, error) { return true, xerrors.Errorf("error") })
+	c.AddJobAfter("job 1", []*Job{j0}, func() (bool, error) { return true, nil })
+	errors := c.Wait()
+	c.Pool.Wait()
+
+	report := newBuildReport(c, errors, 0)
+
+	assert.Len(t, report.Jobs, 2)
+	assert.Equal(t, BuildReportJobStateErrored, report.Jobs[1].State)
+	assert.Equal(t, "dependency 'job 0' failed", report.Jobs[1].Error)
+	assert.Equal(t, -1, report.Jobs[1].Worker)
+}
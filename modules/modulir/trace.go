@@ -0,0 +1,167 @@
+package modulir
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"coolstercodes/modules/modulir/internal/atomicfile"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Thread IDs used in traces. Each worker gets its own thread starting at
+// traceTIDWorkerBase so that jobs are laid out by the worker that ran them.
+const (
+	traceTIDRounds     = 0
+	traceTIDBuildFunc  = 1
+	traceTIDWorkerBase = 2
+)
+
+// trace is a timeline of a build loop in Chrome's trace event format, which
+// can be loaded in Perfetto (ui.perfetto.dev) or Chrome's about:tracing.
+//
+// See: https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+type trace struct {
+	DisplayTimeUnit string        `json:"displayTimeUnit"`
+	TraceEvents     []*traceEvent `json:"traceEvents"`
+}
+
+// traceEvent is a single event in a trace. Timestamps and durations are in
+// microseconds.
+type traceEvent struct {
+	Args      map[string]interface{} `json:"args,omitempty"`
+	Category  string                 `json:"cat,omitempty"`
+	Duration  float64                `json:"dur,omitempty"`
+	Name      string                 `json:"name"`
+	Phase     string                 `json:"ph"`
+	PID       int                    `json:"pid"`
+	TID       int                    `json:"tid"`
+	Timestamp float64                `json:"ts"`
+}
+
+// A period of time during a build. A zero end means that it was still ongoing
+// when the build finished.
+type timeSpan struct {
+	end   time.Time
+	start time.Time
+}
+
+// Produces a trace of the build loop that just finished from the context's
+// statistics. Includes the span of each round, time spent in the build
+// function enqueuing jobs, and every job that ran on the worker that ran it.
+func newTrace(c *Context, duration time.Duration) *trace {
+	start := c.Stats.Start
+	end := start.Add(duration)
+
+	// Microseconds since the start of the build.
+	ts := func(t time.Time) float64 {
+		return float64(t.Sub(start)) / float64(time.Microsecond)
+	}
+
+	dur := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Microsecond)
+	}
+
+	t := &trace{DisplayTimeUnit: "ms"}
+
+	addThread := func(tid int, name string) {
+		t.TraceEvents = append(t.TraceEvents,
+			&traceEvent{Name: "thread_name", Phase: "M", PID: 1, TID: tid,
+				Args: map[string]interface{}{"name": name}},
+			&traceEvent{Name: "thread_sort_index", Phase: "M", PID: 1, TID: tid,
+				Args: map[string]interface{}{"sort_index": tid}},
+		)
+	}
+
+	t.TraceEvents = append(t.TraceEvents, &traceEvent{
+		Name: "process_name", Phase: "M", PID: 1,
+		Args: map[string]interface{}{"name": "modulir build"},
+	})
+
+	addThread(traceTIDRounds, "rounds")
+	addThread(traceTIDBuildFunc, "build function")
+	for i := range c.Pool.concurrency {
+		addThread(traceTIDWorkerBase+i, fmt.Sprintf("worker %d", i))
+	}
+
+	t.TraceEvents = append(t.TraceEvents, &traceEvent{
+		Category: "build", Duration: dur(duration), Name: "build", Phase: "X",
+		PID: 1, TID: traceTIDRounds, Timestamp: 0,
+		Args: map[string]interface{}{
+			"num_jobs":          c.Stats.NumJobs,
+			"num_jobs_errored":  len(c.Stats.JobsErrored),
+			"num_jobs_executed": len(c.Stats.JobsExecuted),
+		},
+	})
+
+	for i, span := range c.Stats.roundSpans {
+		spanEnd := span.end
+		if spanEnd.IsZero() {
+			spanEnd = end
+		}
+
+		t.TraceEvents = append(t.TraceEvents, &traceEvent{
+			Category: "round", Duration: dur(spanEnd.Sub(span.start)),
+			Name: fmt.Sprintf("round %d", i), Phase: "X",
+			PID: 1, TID: traceTIDRounds, Timestamp: ts(span.start),
+		})
+	}
+
+	for _, span := range c.Stats.loopSpans {
+		t.TraceEvents = append(t.TraceEvents, &traceEvent{
+			Category: "build function", Duration: dur(span.end.Sub(span.start)),
+			Name: "build function", Phase: "X",
+			PID: 1, TID: traceTIDBuildFunc, Timestamp: ts(span.start),
+		})
+	}
+
+	for _, job := range c.Stats.JobsAll {
+		// Jobs that never ran (e.g. because a dependency failed) have no
+		// place on the timeline.
+		if job.Worker < 0 {
+			continue
+		}
+
+		args := map[string]interface{}{
+			"executed": job.Executed,
+			"round":    job.Round,
+		}
+		if job.Err != nil {
+			args["error"] = job.Err.Error()
+		}
+
+		t.TraceEvents = append(t.TraceEvents, &traceEvent{
+			Args: args, Category: "job", Duration: dur(job.Duration),
+			Name: job.Name, Phase: "X",
+			PID: 1, TID: traceTIDWorkerBase + job.Worker, Timestamp: ts(job.Start),
+		})
+	}
+
+	return t
+}
+
+// Writes a trace to TracePath.
+func (c *Context) writeTrace(t *trace) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return xerrors.Errorf("error encoding trace: %w", err)
+	}
+
+	if err := atomicfile.Write(c.TracePath, data, 0o644); err != nil {
+		return xerrors.Errorf("error writing trace: %w", err)
+	}
+
+	c.Log.Debugf("Wrote trace to '%s'", c.TracePath)
+	return nil
+}
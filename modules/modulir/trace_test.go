@@ -0,0 +1,78 @@
+package modulir
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestTrace(t *testing.T) {
+	dir := t.TempDir()
+
+	c := NewContext(&Args{
+		Log:       &Logger{Level: LevelWarn},
+		Pool:      NewPool(&Logger{Level: LevelWarn}, 2),
+		TracePath: filepath.Join(dir, "trace.json"),
+	})
+
+	c.ResetBuild()
+	c.StartRound()
+
+	j0 := c.AddJob("job 0", func() (bool, error) {
+		time.Sleep(5 * time.Millisecond)
+		return true, xerrors.Errorf("error")
+	})
+	c.AddJob("job 1", func() (bool, error) { return false, nil })
+	c.AddJobAfter("job 2", []*Job{j0}, func() (bool, error) { return true, nil })
+	c.Wait()
+	c.Pool.Wait()
+
+	assert.NoError(t, c.writeTrace(newTrace(c, time.Since(c.Stats.Start))))
+
+	data, err := os.ReadFile(c.TracePath)
+	assert.NoError(t, err)
+
+	var decoded trace
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+
+	eventsByCategory := make(map[string][]*traceEvent)
+	threadNames := make(map[int]string)
+	for _, event := range decoded.TraceEvents {
+		if event.Phase == "M" && event.Name == "thread_name" {
+			threadNames[event.TID] = event.Args["name"].(string)
+			continue
+		}
+		eventsByCategory[event.Category] = append(eventsByCategory[event.Category], event)
+	}
+
+	assert.Equal(t, map[int]string{
+		0: "rounds",
+		1: "build function",
+		2: "worker 0",
+		3: "worker 1",
+	}, threadNames)
+
+	assert.Len(t, eventsByCategory["build"], 1)
+	assert.Len(t, eventsByCategory["round"], 2)
+	assert.Len(t, eventsByCategory["build function"], 1)
+
+	// Job 2 never ran because job 0 failed, so it's not on the timeline.
+	jobEvents := eventsByCategory["job"]
+	assert.Len(t, jobEvents, 2)
+
+	for _, event := range jobEvents {
+		assert.Equal(t, "X", event.Phase)
+		assert.GreaterOrEqual(t, event.TID, traceTIDWorkerBase)
+		assert.GreaterOrEqual(t, event.Timestamp, 0.0)
+
+		if event.Name == "job 0" {
+			assert.Equal(t, "error", event.Args["error"])
+			assert.GreaterOrEqual(t, event.Duration, 5000.0)
+		}
+	}
+}
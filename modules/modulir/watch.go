@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"golang.org/x/xerrors"
 )
 
 //////////////////////////////////////////////////////////////////////////////
@@ -84,6 +85,11 @@ func watchChanges(c *Context, watchEvents chan fsnotify.Event, watchErrors chan
 
 				lastRebuild = time.Now()
 
+				// If a build is still in progress (e.g. the first build, or
+				// one for a scheduled rebuild), its output will be stale by
+				// the time it finishes, so interrupt it.
+				c.cancelBuild(xerrors.Errorf("change detected on %v", mapKeys(changedSources)))
+
 				// Start rebuild
 				rebuild <- changedSources
 
@@ -114,6 +120,16 @@ func watchChanges(c *Context, watchEvents chan fsnotify.Event, watchErrors chan
 							continue
 						}
 
+						// A change to a file other than the ones being
+						// rebuilt means that the build's output will be stale
+						// by the time it finishes, so interrupt it to start
+						// the next one sooner. Changes on the same files are
+						// often duplicate events from a single save, so those
+						// are left to the next build.
+						if _, ok := lastChangedSources[event.Name]; !ok {
+							c.cancelBuild(xerrors.Errorf("change detected on %s", event.Name))
+						}
+
 						if changedSources == nil {
 							changedSources = make(map[string]struct{})
 						}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"coolstercodes/modules/modulir/massets"
 	"coolstercodes/modules/modulir/mtemplate"
 	"coolstercodes/modules/modulir/mtemplatemd"
 )
@@ -21,6 +22,15 @@ import (
 //////////////////////////////////////////////////////////////////////////////
 
 const (
+	// AtomAuthorName is the name of the author to include in Atom and JSON
+	// feeds.
+	AtomAuthorName = "Coolster Codes"
+
+	// AtomTag is a stable constant to use in Atom tags (i.e. unique IDs for
+	// feeds and their entries). It should never change once feeds have been
+	// published.
+	AtomTag = "coolstercodes.com"
+
 	// LayoutsDir is the source directory for view layouts.
 	LayoutsDir = "./web/html/layouts"
 
@@ -34,6 +44,10 @@ const (
 
 	// HTML is the source directory for html.
 	HTML = "./web/html"
+
+	// NumAtomEntries is the number of entries to include in Atom and JSON
+	// feeds.
+	NumAtomEntries = 20
 )
 
 //////////////////////////////////////////////////////////////////////////////
@@ -47,8 +61,9 @@ const (
 //////////////////////////////////////////////////////////////////////////////
 
 // HTMLTemplateFuncMap is a function map of template helpers which is the
-// combined version of the maps from ftemplate, mtemplate, and mtemplatemd.
+// combined version of the maps from massets, mtemplate, and mtemplatemd.
 var HTMLTemplateFuncMap = mtemplate.CombineFuncMaps(
+	massets.FuncMap,
 	mtemplate.FuncMap,
 	mtemplatemd.FuncMap,
 )